@@ -3,18 +3,240 @@ package luminosity
 import (
 	"fmt"
 	"math"
+	"strconv"
 )
 
+// ----------------------------------------------------------------------
+// APEX Conversions
+// ----------------------------------------------------------------------
+
 // ApertureToFNumber converts an APEX aperture value to the more
 // familiar expression of aperture as an f-number.
 func ApertureToFNumber(a float64) float64 {
 	return math.Exp2(a / 2)
 }
 
+// ShutterSpeedToSeconds converts an APEX shutter speed value to the
+// exposure time in seconds.
+func ShutterSpeedToSeconds(a float64) float64 {
+	return math.Exp2(-a)
+}
+
 // ShutterSpeedToExposureTime converts an APEX exposure time value to
-// the conventional expression of exposure time in fractions of a
-// second.
+// the conventional expression of exposure time, snapped to the
+// nearest standard third stop - fractions of a second for short
+// exposures (e.g. "1/250"), and seconds for long ones (e.g. "2s").
 func ShutterSpeedToExposureTime(a float64) string {
-	exposure := math.Exp2(a)
-	return fmt.Sprintf("1/%.0f", exposure)
+	return FormatExposureTime(SnapExposureTime(ShutterSpeedToSeconds(a), ThirdStop))
+}
+
+// ApertureToFNumberString converts an APEX aperture value to an
+// f-number snapped to the nearest standard third stop, formatted
+// without the "f/" prefix (e.g. "2.8", "11").
+func ApertureToFNumberString(a float64) string {
+	return FormatFNumber(SnapFNumber(ApertureToFNumber(a), ThirdStop))
+}
+
+// ISOToSpeedValue converts an ISO arithmetic film speed to the
+// equivalent APEX speed value. ISO 100 corresponds to a speed value
+// of 5.
+func ISOToSpeedValue(iso float64) float64 {
+	return math.Log2(iso / 3.125)
+}
+
+// ExposureValue returns the APEX exposure value for the given APEX
+// aperture and shutter speed values, which is simply their sum.
+func ExposureValue(aperture, shutterSpeed float64) float64 {
+	return aperture + shutterSpeed
+}
+
+// LightValue returns the exposure value normalized to ISO 100
+// (EV100), which measures the brightness of the scene rather than the
+// camera settings used to capture it.
+func LightValue(aperture, shutterSpeed, iso float64) float64 {
+	return ExposureValue(aperture, shutterSpeed) - math.Log2(iso/100)
+}
+
+// ----------------------------------------------------------------------
+// Formatting
+// ----------------------------------------------------------------------
+
+// FormatExposureTime renders an exposure time in seconds in the
+// conventional notation - fractions of a second below 0.3s, and
+// decimal or whole seconds from there up.
+func FormatExposureTime(seconds float64) string {
+	switch {
+	case seconds <= 0:
+		return ""
+	case seconds < 0.3:
+		return fmt.Sprintf("1/%.0f", 1/seconds)
+	case seconds < 10:
+		return strconv.FormatFloat(math.Round(seconds*10)/10, 'f', -1, 64) + "s"
+	default:
+		return fmt.Sprintf("%.0fs", seconds)
+	}
+}
+
+// FormatFNumber renders an f-number with at most one decimal place,
+// and none for f/10 and above (e.g. "1.4", "8", "22").
+func FormatFNumber(f float64) string {
+	if f >= 10 {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64)
+}
+
+// ----------------------------------------------------------------------
+// Standard Stops
+// ----------------------------------------------------------------------
+
+// StopIncrement identifies the granularity of a sequence of standard
+// camera settings.
+type StopIncrement int
+
+const (
+	ThirdStop StopIncrement = iota
+	HalfStop
+	FullStop
+)
+
+func (s StopIncrement) String() string {
+	switch s {
+	case FullStop:
+		return "full"
+	case HalfStop:
+		return "half"
+	case ThirdStop:
+		return "third"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	// FullStopFNumbers is the nominal sequence of f-numbers in full
+	// stop increments.
+	FullStopFNumbers = []float64{
+		0.7, 1.0, 1.4, 2.0, 2.8, 4.0, 5.6, 8, 11, 16, 22, 32, 45, 64,
+	}
+
+	// HalfStopFNumbers is the nominal sequence of f-numbers in half
+	// stop increments.
+	HalfStopFNumbers = []float64{
+		0.7, 0.85, 1.0, 1.2, 1.4, 1.7, 2.0, 2.4, 2.8, 3.3, 4.0, 4.8, 5.6,
+		6.7, 8, 9.5, 11, 13, 16, 19, 22, 27, 32, 38, 45, 54, 64,
+	}
+
+	// ThirdStopFNumbers is the nominal sequence of f-numbers in
+	// one-third stop increments.
+	ThirdStopFNumbers = []float64{
+		0.7, 0.8, 0.9, 1.0, 1.1, 1.2, 1.4, 1.6, 1.8, 2.0, 2.2, 2.5, 2.8,
+		3.2, 3.5, 4.0, 4.5, 5.0, 5.6, 6.3, 7.1, 8, 9, 10, 11, 13, 14, 16,
+		18, 20, 22, 25, 29, 32, 36, 40, 45, 51, 57, 64,
+	}
+
+	// FullStopExposureTimes is the nominal sequence of exposure
+	// times, in seconds, in full stop increments.
+	FullStopExposureTimes = []float64{
+		1.0 / 8000, 1.0 / 4000, 1.0 / 2000, 1.0 / 1000, 1.0 / 500,
+		1.0 / 250, 1.0 / 125, 1.0 / 60, 1.0 / 30, 1.0 / 15, 1.0 / 8,
+		1.0 / 4, 0.5, 1, 2, 4, 8, 15, 30,
+	}
+
+	// HalfStopExposureTimes is the nominal sequence of exposure
+	// times, in seconds, in half stop increments.
+	HalfStopExposureTimes = []float64{
+		1.0 / 8000, 1.0 / 6000, 1.0 / 4000, 1.0 / 3000, 1.0 / 2000,
+		1.0 / 1500, 1.0 / 1000, 1.0 / 750, 1.0 / 500, 1.0 / 350,
+		1.0 / 250, 1.0 / 180, 1.0 / 125, 1.0 / 90, 1.0 / 60, 1.0 / 45,
+		1.0 / 30, 1.0 / 20, 1.0 / 15, 1.0 / 10, 1.0 / 8, 1.0 / 6, 1.0 / 4,
+		0.3, 0.5, 0.7, 1, 1.5, 2, 3, 4, 6, 8, 12, 15, 20, 30,
+	}
+
+	// ThirdStopExposureTimes is the nominal sequence of exposure
+	// times, in seconds, in one-third stop increments.
+	ThirdStopExposureTimes = []float64{
+		1.0 / 8000, 1.0 / 6400, 1.0 / 5000, 1.0 / 4000, 1.0 / 3200,
+		1.0 / 2500, 1.0 / 2000, 1.0 / 1600, 1.0 / 1250, 1.0 / 1000,
+		1.0 / 800, 1.0 / 640, 1.0 / 500, 1.0 / 400, 1.0 / 320, 1.0 / 250,
+		1.0 / 200, 1.0 / 160, 1.0 / 125, 1.0 / 100, 1.0 / 80, 1.0 / 60,
+		1.0 / 50, 1.0 / 40, 1.0 / 30, 1.0 / 25, 1.0 / 20, 1.0 / 15,
+		1.0 / 13, 1.0 / 10, 1.0 / 8, 1.0 / 6, 1.0 / 5, 1.0 / 4, 0.3, 0.4,
+		0.5, 0.6, 0.8, 1, 1.3, 1.6, 2, 2.5, 3.2, 4, 5, 6, 8, 10, 13, 15,
+		20, 25, 30,
+	}
+
+	// ThirdStopISOs is the nominal sequence of ISO speeds in
+	// one-third stop increments.
+	ThirdStopISOs = []float64{
+		50, 64, 80, 100, 125, 160, 200, 250, 320, 400, 500, 640, 800,
+		1000, 1250, 1600, 2000, 2500, 3200, 4000, 5000, 6400, 8000,
+		10000, 12800, 16000, 20000, 25600, 32000, 40000, 51200, 64000,
+		80000, 102400, 204800, 409600,
+	}
+)
+
+// FNumbers returns the nominal sequence of f-numbers for the stop
+// increment.
+func (s StopIncrement) FNumbers() []float64 {
+	switch s {
+	case FullStop:
+		return FullStopFNumbers
+	case HalfStop:
+		return HalfStopFNumbers
+	default:
+		return ThirdStopFNumbers
+	}
+}
+
+// ExposureTimes returns the nominal sequence of exposure times, in
+// seconds, for the stop increment.
+func (s StopIncrement) ExposureTimes() []float64 {
+	switch s {
+	case FullStop:
+		return FullStopExposureTimes
+	case HalfStop:
+		return HalfStopExposureTimes
+	default:
+		return ThirdStopExposureTimes
+	}
+}
+
+// snapToNominal returns the value in the sorted nominal sequence
+// closest to v, measured in stops. Values more than half a stop
+// outside the sequence, such as bulb exposures, are returned
+// unchanged.
+func snapToNominal(v float64, nominal []float64) float64 {
+	if v <= 0 || len(nominal) == 0 {
+		return v
+	}
+	best := nominal[0]
+	distance := math.Abs(math.Log2(v / best))
+	for _, n := range nominal[1:] {
+		if d := math.Abs(math.Log2(v / n)); d < distance {
+			best, distance = n, d
+		}
+	}
+	if distance > 0.5 {
+		return v
+	}
+	return best
+}
+
+// SnapFNumber returns the standard f-number closest to f, in the
+// sequence for the given stop increment.
+func SnapFNumber(f float64, inc StopIncrement) float64 {
+	return snapToNominal(f, inc.FNumbers())
+}
+
+// SnapExposureTime returns the standard exposure time closest to the
+// given exposure time in seconds, in the sequence for the given stop
+// increment.
+func SnapExposureTime(seconds float64, inc StopIncrement) float64 {
+	return snapToNominal(seconds, inc.ExposureTimes())
+}
+
+// SnapISO returns the standard third stop ISO speed closest to iso.
+func SnapISO(iso float64) float64 {
+	return snapToNominal(iso, ThirdStopISOs)
 }
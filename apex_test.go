@@ -0,0 +1,42 @@
+package luminosity
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSnapToNominal(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       float64
+		nominal []float64
+		want    float64
+	}{
+		// f-numbers are powers of sqrt(2), the marked values rounded
+		{"f/5.6 exact", math.Pow(math.Sqrt2, 5), ThirdStopFNumbers, 5.6},
+		{"f/6.3 third stop", math.Pow(math.Sqrt2, 5+1.0/3), ThirdStopFNumbers, 6.3},
+		{"f/7.1 third stop", math.Pow(math.Sqrt2, 5+2.0/3), ThirdStopFNumbers, 7.1},
+		{"f/3.3 half stop", math.Pow(math.Sqrt2, 3.5), HalfStopFNumbers, 3.3},
+		{"f/5 to full stop", 5, FullStopFNumbers, 5.6},
+		{"f/1.0", 1, ThirdStopFNumbers, 1},
+
+		// Exposure times are powers of 2
+		{"1/256s", 1.0 / 256, ThirdStopExposureTimes, 1.0 / 250},
+		{"1/64s", 1.0 / 64, ThirdStopExposureTimes, 1.0 / 60},
+		{"1/64s full stop", 1.0 / 64, FullStopExposureTimes, 1.0 / 60},
+		{"1/8192s", 1.0 / 8192, ThirdStopExposureTimes, 1.0 / 8000},
+		{"40s within half a stop", 40, ThirdStopExposureTimes, 30},
+
+		// Out of range values are returned unchanged
+		{"bulb", 120, ThirdStopExposureTimes, 120},
+		{"too fast", 1.0 / 32000, ThirdStopExposureTimes, 1.0 / 32000},
+		{"zero", 0, ThirdStopFNumbers, 0},
+		{"negative", -1, ThirdStopFNumbers, -1},
+		{"no sequence", 4.2, nil, 4.2},
+	}
+	for _, test := range tests {
+		if got := snapToNominal(test.v, test.nominal); got != test.want {
+			t.Errorf("%s: snapToNominal(%g) = %g, want %g", test.name, test.v, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package luminosity
+
+import (
+	"fmt"
+	"os"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// attribute records the count of every entry of the list as coming
+// from the named catalog. Entries which are already attributed, such
+// as those of previously merged stats, are left unchanged.
+func (l DistributionList) attribute(catalog string) {
+	for _, e := range l {
+		if e.ByCatalog == nil && e.Count != 0 {
+			e.ByCatalog = map[string]int64{catalog: e.Count}
+		}
+	}
+}
+
+// Attribute records the counts of every distribution entry as coming
+// from the named catalog, so that the per-catalog breakdown of each
+// entry is preserved when the stats are merged with others.
+func (s *Stats) Attribute(catalog string) {
+	for _, d := range s.mergedDistributions() {
+		d.List.attribute(catalog)
+	}
+}
+
+// CatalogSummary describes one of the catalogs making up a merged
+// catalog.
+type CatalogSummary struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Count is the number of photos in the catalog, excluding videos
+	// when StatsOptions.ExcludeVideos is set and photos outside of
+	// StatsOptions.Window.
+	Count        int64     `json:"count"`
+	FirstCapture null.Time `json:"first_capture"`
+	LastCapture  null.Time `json:"last_capture"`
+
+	// Size is the size of the catalog file in bytes, not including
+	// previews.
+	Size int64 `json:"size"`
+}
+
+// GetSummary returns the photo count, capture date range and file
+// size of the catalog.
+func (c *Catalog) GetSummary() (*CatalogSummary, error) {
+	const query = `
+SELECT count(*),
+       min(captureTime),
+       max(captureTime)
+FROM   Adobe_images
+WHERE  %s
+`
+	summary := &CatalogSummary{
+		Name: c.Name(),
+		Path: c.Path(),
+	}
+	if info, err := os.Stat(c.Path()); err != nil {
+		return nil, err
+	} else {
+		summary.Size = info.Size()
+	}
+
+	var first, last null.String
+	row := c.db.queryRow("get_summary", fmt.Sprintf(query, c.imageCondition("id_local")))
+	if err := row.Scan(&summary.Count, &first, &last); err != nil {
+		return nil, err
+	}
+	summary.FirstCapture = c.summaryTime(first)
+	summary.LastCapture = c.summaryTime(last)
+	return summary, nil
+}
+
+func (c *Catalog) summaryTime(s null.String) null.Time {
+	if s.Valid {
+		if t, _, err := parseCaptureTime(s.String); err == nil {
+			return null.TimeFrom(c.StatsOptions.TimeBasis.In(t))
+		}
+	}
+	return null.Time{}
+}
@@ -0,0 +1,255 @@
+package luminosity
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// cameraMakeRule maps camera model names starting with a prefix to
+// the name of their manufacturer.
+type cameraMakeRule struct {
+	prefix string
+	make   string
+}
+
+// cameraMakeRules are checked in order against the lower-cased
+// camera model name.
+var cameraMakeRules = []cameraMakeRule{
+	{"canon", "Canon"},
+	{"nikon", "Nikon"},
+	{"x-", "Fujifilm"},
+	{"x100", "Fujifilm"},
+	{"x70", "Fujifilm"},
+	{"xf10", "Fujifilm"},
+	{"gfx", "Fujifilm"},
+	{"finepix", "Fujifilm"},
+	{"ilce-", "Sony"},
+	{"ilca-", "Sony"},
+	{"nex-", "Sony"},
+	{"dsc-", "Sony"},
+	{"slt-", "Sony"},
+	{"e-m", "Olympus"},
+	{"e-p", "Olympus"},
+	{"om-", "OM System"},
+	{"dmc-", "Panasonic"},
+	{"dc-", "Panasonic"},
+	{"leica", "Leica"},
+	{"pentax", "Pentax"},
+	{"ricoh", "Ricoh"},
+	{"hasselblad", "Hasselblad"},
+	{"iphone", "Apple"},
+	{"ipad", "Apple"},
+	{"pixel", "Google"},
+	{"hero", "GoPro"},
+	{"sm-", "Samsung"},
+}
+
+// CameraMake returns the name of the manufacturer of a camera
+// model. Lightroom does not intern the EXIF make of each photo, so it
+// is inferred from each manufacturer's model naming conventions. An
+// empty string is returned for unrecognized models.
+func CameraMake(model string) string {
+	key := strings.ToLower(strings.TrimSpace(model))
+	for _, rule := range cameraMakeRules {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule.make
+		}
+	}
+	return ""
+}
+
+// CameraBody records the usage of a single physical camera, as
+// identified by its model and serial number.
+type CameraBody struct {
+	Make   string `json:"make"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+
+	// Count is the number of photos from the body in the catalog.
+	Count     int64     `json:"count"`
+	FirstUsed time.Time `json:"first_used"`
+	LastUsed  time.Time `json:"last_used"`
+
+	// EstimatedActuations estimates the number of times the shutter
+	// was released between the first and last photo in the catalog,
+	// including frames which were deleted or never imported. It is
+	// derived from the frame counter in the original file names, and
+	// falls back to Count when file names are not numbered.
+	EstimatedActuations int64 `json:"estimated_actuations"`
+}
+
+// Key returns a string uniquely identifying the body.
+func (b *CameraBody) Key() string {
+	return b.Model + "\x00" + b.Serial
+}
+
+// merge accumulates the usage of other, which must be the same body,
+// into b.
+func (b *CameraBody) merge(other *CameraBody) {
+	b.Count += other.Count
+	b.EstimatedActuations += other.EstimatedActuations
+	if b.FirstUsed.IsZero() || (!other.FirstUsed.IsZero() && other.FirstUsed.Before(b.FirstUsed)) {
+		b.FirstUsed = other.FirstUsed
+	}
+	if other.LastUsed.After(b.LastUsed) {
+		b.LastUsed = other.LastUsed
+	}
+}
+
+type CameraBodyList []*CameraBody
+
+func (l CameraBodyList) Len() int      { return len(l) }
+func (l CameraBodyList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l CameraBodyList) Less(i, j int) bool {
+	if l[i].Model != l[j].Model {
+		return l[i].Model < l[j].Model
+	}
+	return l[i].Serial < l[j].Serial
+}
+
+// Merge combines two lists of camera bodies, summing the usage of
+// bodies present in both. Estimated actuations are summed, so they
+// will over-count if the same photos appear in several catalogs.
+func (l CameraBodyList) Merge(other CameraBodyList) CameraBodyList {
+	m := map[string]*CameraBody{}
+	var merged CameraBodyList
+	for _, list := range []CameraBodyList{l, other} {
+		for _, b := range list {
+			if target, ok := m[b.Key()]; ok {
+				target.merge(b)
+			} else {
+				c := *b
+				m[b.Key()] = &c
+				merged = append(merged, &c)
+			}
+		}
+	}
+	sort.Sort(merged)
+	return merged
+}
+
+// frameNumberPattern matches the trailing frame counter of camera
+// file names, such as DSCF1234 or IMG_0042.
+var frameNumberPattern = regexp.MustCompile(`(\d+)\D*$`)
+
+// frameCounter tracks the frame numbers of successive photos from a
+// camera body to estimate the number of shutter actuations.
+type frameCounter struct {
+	last     int64
+	modulus  int64
+	frames   int64
+	numbered bool
+}
+
+// add records the next file name in capture order.
+func (f *frameCounter) add(filename string) {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	match := frameNumberPattern.FindStringSubmatch(base)
+	if match == nil {
+		f.frames++
+		return
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		f.frames++
+		return
+	}
+	// Counters wrap around when they reach their maximum number of
+	// digits (e.g. 9999 -> 0001)
+	modulus := int64(math.Pow10(len(match[1])))
+	if !f.numbered || modulus != f.modulus {
+		f.frames++
+	} else if step := ((n-f.last)%modulus + modulus) % modulus; step > 0 {
+		f.frames += step
+	}
+	f.numbered = true
+	f.last = n
+	f.modulus = modulus
+}
+
+// GetCameraBodies returns the usage of each individual camera body
+// in the catalog, distinguishing bodies of the same model by the
+// serial number recorded in the EXIF metadata. Virtual copies are not
+// counted.
+func (c *Catalog) GetCameraBodies() (CameraBodyList, error) {
+	const query = `
+SELECT    Camera.value,
+          CameraSN.value,
+          image.captureTime,
+          coalesce(file.originalFilename, file.baseName)
+FROM      Adobe_images              image
+JOIN      AgLibraryFile             file     ON     file.id_local = image.rootFile
+JOIN      AgHarvestedExifMetadata   exif     ON    image.id_local = exif.image
+JOIN      AgInternedExifCameraModel Camera   ON   Camera.id_local = exif.cameraModelRef
+LEFT JOIN AgInternedExifCameraSN    CameraSN ON CameraSN.id_local = exif.cameraSNRef
+WHERE     image.masterImage is null
+AND       %s
+ORDER BY  image.captureTime
+`
+	if c.CameraBodies != nil {
+		return c.CameraBodies, nil
+	}
+
+	rows, err := c.db.query("get_camera_bodies", fmt.Sprintf(query, c.imageCondition("image.id_local")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bodies := map[string]*CameraBody{}
+	counters := map[string]*frameCounter{}
+	var list CameraBodyList
+	for rows.Next() {
+		var model, serial, captureTime, filename null.String
+		if err := rows.Scan(&model, &serial, &captureTime, &filename); err != nil {
+			return nil, err
+		}
+		name := c.StatsOptions.Names.Camera(model.String)
+		body := &CameraBody{
+			Make:   CameraMake(name),
+			Model:  name,
+			Serial: serial.String,
+			Count:  1,
+		}
+		if captureTime.Valid {
+			if t, _, err := parseCaptureTime(captureTime.String); err == nil {
+				body.FirstUsed = t
+				body.LastUsed = t
+			}
+		}
+		key := body.Key()
+		if target, ok := bodies[key]; ok {
+			target.merge(body)
+		} else {
+			bodies[key] = body
+			counters[key] = &frameCounter{}
+			list = append(list, body)
+		}
+		counters[key].add(filename.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for key, body := range bodies {
+		body.EstimatedActuations = counters[key].frames
+	}
+	sort.Sort(list)
+
+	log.WithFields(log.Fields{
+		"action": "get_camera_bodies",
+		"count":  len(list),
+	}).Debug()
+
+	c.CameraBodies = list
+	return c.CameraBodies, nil
+}
@@ -0,0 +1,224 @@
+package luminosity
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// CacheVersion is the version of the format of the data stored in
+// the catalog cache. It must be incremented whenever the information
+// extracted from catalogs changes, so that older cache entries are
+// ignored.
+const CacheVersion = 2
+
+// CatalogCache is a persistent cache of the information loaded from
+// catalogs - stats, lens and camera lists, collection trees, etc... -
+// so that catalogs which have not changed since they were last loaded
+// do not need to be read again. Entries are keyed by the catalog path,
+// modification time, size and Lightroom schema version, the cache
+// version, and the stats options they were computed with. Photo
+// records are not cached.
+type CatalogCache struct {
+	Dir string
+}
+
+// cacheEntry is the content of a cache file.
+type cacheEntry struct {
+	Version int       `json:"version"`
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Schema  string    `json:"schema"`
+	Options string    `json:"options"`
+	Catalog *Catalog  `json:"catalog"`
+}
+
+// DefaultCatalogCacheDir returns the directory of the catalog cache
+// under the user's cache directory.
+func DefaultCatalogCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "luminosity"), nil
+}
+
+// OpenCatalogCache returns a cache storing its entries in dir, which
+// is created if it does not exist.
+func OpenCatalogCache(dir string) (*CatalogCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CatalogCache{Dir: dir}, nil
+}
+
+// entryPath returns the path of the cache file for a catalog.
+func (cc *CatalogCache) entryPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(cc.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// optionsKey returns a fingerprint of the stats options which affect
+// the information loaded from a catalog.
+func optionsKey(options StatsOptions) (string, error) {
+	// Attribution only happens when merging, after loading
+	options.AttributeCatalogs = false
+	js, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// schemaVersion returns the version of the Lightroom schema of the
+// catalog at path, as recorded in Adobe_variablesTable, or an empty
+// string if the catalog does not record it.
+func schemaVersion(path string) (string, error) {
+	const query = `
+SELECT value
+FROM   Adobe_variablesTable
+WHERE  name = 'Adobe_DBVersion'
+`
+	db, err := OpenDBReadOnly(path)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	var tables int
+	if err := db.queryRow("get_schema_tables",
+		"SELECT count(*) FROM sqlite_master WHERE name = 'Adobe_variablesTable'").Scan(&tables); err != nil {
+		return "", err
+	}
+	if tables == 0 {
+		return "", nil
+	}
+	var version null.String
+	if err := db.queryRow("get_schema_version", query).Scan(&version); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return version.String, nil
+}
+
+// newCacheEntry returns the cache key of the catalog at path, as it
+// is currently on disk, for the given options.
+func newCacheEntry(path string, options StatsOptions) (*cacheEntry, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := schemaVersion(abs)
+	if err != nil {
+		return nil, err
+	}
+	key, err := optionsKey(options)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{
+		Version: CacheVersion,
+		Path:    abs,
+		ModTime: info.ModTime().UTC(),
+		Size:    info.Size(),
+		Schema:  schema,
+		Options: key,
+	}, nil
+}
+
+func (e *cacheEntry) matches(other *cacheEntry) bool {
+	return e.Version == other.Version &&
+		e.Path == other.Path &&
+		e.ModTime.Equal(other.ModTime) &&
+		e.Size == other.Size &&
+		e.Schema == other.Schema &&
+		e.Options == other.Options
+}
+
+// Get returns the cached information of the catalog at path, if the
+// catalog has not changed since it was cached with the same options.
+// The returned catalog has no database connection. Unreadable or
+// stale entries are treated as misses.
+func (cc *CatalogCache) Get(path string, options StatsOptions) (*Catalog, bool) {
+	key, err := newCacheEntry(path, options)
+	if err != nil {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(cc.entryPath(key.Path))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.WithFields(log.Fields{
+			"action":  "cache_get",
+			"catalog": path,
+			"error":   err,
+		}).Warn("Error reading cache entry, ignoring")
+		return nil, false
+	}
+	if !entry.matches(key) || entry.Catalog == nil {
+		log.WithFields(log.Fields{
+			"action":  "cache_get",
+			"catalog": path,
+			"status":  "stale",
+		}).Debug()
+		return nil, false
+	}
+	entry.Catalog.Paths = []string{path}
+	entry.Catalog.StatsOptions = options
+	log.WithFields(log.Fields{
+		"action":  "cache_get",
+		"catalog": path,
+		"status":  "hit",
+	}).Debug()
+	return entry.Catalog, true
+}
+
+// Put stores the loaded information of a catalog in the cache. It
+// must be called before the catalog is merged with others.
+func (cc *CatalogCache) Put(c *Catalog) error {
+	entry, err := newCacheEntry(c.Path(), c.StatsOptions)
+	if err != nil {
+		return err
+	}
+	entry.Catalog = c
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a concurrent or
+	// interrupted run never leaves a partial entry behind
+	tmp, err := ioutil.TempFile(cc.Dir, "entry-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cc.entryPath(entry.Path)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing cache entry for %s: %s", c.Path(), err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package luminosity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// LocalTimeFormat is the layout used to render wall-clock capture
+	// times, which carry no time zone designation.
+	LocalTimeFormat = "2006-01-02T15:04:05.999999999"
+)
+
+// captureTimeFormats lists every layout Lightroom is known to write
+// to Adobe_images.captureTime. Layouts carrying a zone designation
+// come first, so that an offset is never silently dropped. Fractional
+// seconds are optional in every layout.
+var captureTimeFormats = []struct {
+	layout    string
+	hasOffset bool
+}{
+	{"2006-01-02T15:04:05.999999999Z07:00", true},
+	{"2006-01-02T15:04:05.999999999Z0700", true},
+	{"2006-01-02T15:04Z07:00", true},
+	{"2006-01-02T15:04:05.999999999", false},
+	{"2006-01-02 15:04:05.999999999", false},
+	{"2006-01-02T15:04", false},
+	{"2006-01-02", false},
+	{"2006-01", false},
+	{"2006", false},
+}
+
+// parseCaptureTime parses a Lightroom capture time string, returning
+// the parsed time and whether the string included an offset from
+// UTC. Times without an offset are returned in UTC with their
+// wall-clock fields intact.
+func parseCaptureTime(s string) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	var err error
+	for _, f := range captureTimeFormats {
+		var t time.Time
+		if t, err = time.Parse(f.layout, s); err == nil {
+			return t, f.hasOffset, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("Unrecognized capture time %q: %s", s, err)
+}
+
+// TimeBasis selects how capture times are interpreted when photos
+// are bucketed by date.
+type TimeBasis int
+
+const (
+	// TimeBasisLocal buckets photos by the wall-clock time at the
+	// place of capture.
+	TimeBasisLocal TimeBasis = iota
+	// TimeBasisUTC buckets photos by UTC. Photos whose capture time
+	// has no recorded offset are treated as if they were shot in
+	// UTC.
+	TimeBasisUTC
+)
+
+func (b TimeBasis) String() string {
+	switch b {
+	case TimeBasisLocal:
+		return "local"
+	case TimeBasisUTC:
+		return "utc"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTimeBasis converts the name of a time basis ("local" or
+// "utc") to a TimeBasis value.
+func ParseTimeBasis(s string) (TimeBasis, error) {
+	switch strings.ToLower(s) {
+	case "local", "":
+		return TimeBasisLocal, nil
+	case "utc":
+		return TimeBasisUTC, nil
+	default:
+		return TimeBasisLocal, fmt.Errorf("Unknown time basis %q", s)
+	}
+}
+
+// In returns t expressed according to the time basis. For the local
+// basis the wall-clock time is preserved as-is; for the UTC basis t
+// is converted to UTC.
+func (b TimeBasis) In(t time.Time) time.Time {
+	if b == TimeBasisUTC {
+		return t.UTC()
+	}
+	return t
+}
+
+// orderingKey returns a time which orders and measures intervals
+// between capture times according to the time basis. For the local
+// basis the wall-clock time is used, ignoring the UTC offsets, so
+// that photos are ordered as they were shot by a camera travelling
+// across time zones.
+func (b TimeBasis) orderingKey(t time.Time) time.Time {
+	if b == TimeBasisUTC {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// dateExpression returns the SQL expression which extracts the
+// calendar date of Adobe_images.captureTime according to the time
+// basis. SQLite's date() function normalizes offsets to UTC, so the
+// local date is taken from the leading wall-clock characters.
+func (b TimeBasis) dateExpression(column string) string {
+	if b == TimeBasisUTC {
+		return fmt.Sprintf("date(%s)", column)
+	}
+	return fmt.Sprintf("date(substr(%s, 1, 10))", column)
+}
@@ -0,0 +1,67 @@
+package luminosity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCaptureTime(t *testing.T) {
+	tests := []struct {
+		input     string
+		want      string
+		offset    int
+		hasOffset bool
+	}{
+		// No offset: wall-clock time, kept in UTC
+		{"2018-06-01T09:00:01", "2018-06-01T09:00:01", 0, false},
+		{"2018-06-01 09:00:01", "2018-06-01T09:00:01", 0, false},
+		{"2018-06-01T09:00", "2018-06-01T09:00:00", 0, false},
+		{"2018-06-01", "2018-06-01T00:00:00", 0, false},
+		{"2018-06", "2018-06-01T00:00:00", 0, false},
+		{"2018", "2018-01-01T00:00:00", 0, false},
+		{"  2018-06-01T09:00:01  ", "2018-06-01T09:00:01", 0, false},
+
+		// Zulu
+		{"2018-06-01T09:00:01Z", "2018-06-01T09:00:01", 0, true},
+		{"2018-06-01T09:00Z", "2018-06-01T09:00:00", 0, true},
+
+		// Offsets, with and without a colon
+		{"2018-06-01T09:00:01+02:00", "2018-06-01T09:00:01", 2 * 3600, true},
+		{"2018-06-01T09:00:01-05:00", "2018-06-01T09:00:01", -5 * 3600, true},
+		{"2018-06-01T09:00:01+0530", "2018-06-01T09:00:01", 5*3600 + 1800, true},
+		{"2018-06-01T09:00+01:00", "2018-06-01T09:00:00", 3600, true},
+
+		// Fractional seconds
+		{"2018-06-01T09:00:01.45", "2018-06-01T09:00:01.45", 0, false},
+		{"2018-06-01T09:00:01.123456789", "2018-06-01T09:00:01.123456789", 0, false},
+		{"2018-06-01T09:00:01.12-05:00", "2018-06-01T09:00:01.12", -5 * 3600, true},
+		{"2018-06-01T09:00:01.5Z", "2018-06-01T09:00:01.5", 0, true},
+	}
+	for _, test := range tests {
+		got, hasOffset, err := parseCaptureTime(test.input)
+		if err != nil {
+			t.Errorf("parseCaptureTime(%q): unexpected error %s", test.input, err)
+			continue
+		}
+		if s := got.Format(LocalTimeFormat); s != test.want {
+			t.Errorf("parseCaptureTime(%q) = %s, want %s", test.input, s, test.want)
+		}
+		if _, offset := got.Zone(); offset != test.offset {
+			t.Errorf("parseCaptureTime(%q) offset = %d, want %d", test.input, offset, test.offset)
+		}
+		if hasOffset != test.hasOffset {
+			t.Errorf("parseCaptureTime(%q) hasOffset = %t, want %t", test.input, hasOffset, test.hasOffset)
+		}
+		if !test.hasOffset && got.Location() != time.UTC {
+			t.Errorf("parseCaptureTime(%q) location = %s, want UTC", test.input, got.Location())
+		}
+	}
+}
+
+func TestParseCaptureTimeErrors(t *testing.T) {
+	for _, input := range []string{"", "yesterday", "2018-13-01", "2018-06-01T25:00:00", "01/06/2018"} {
+		if got, _, err := parseCaptureTime(input); err == nil {
+			t.Errorf("parseCaptureTime(%q) = %s, want an error", input, got)
+		}
+	}
+}
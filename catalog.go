@@ -2,6 +2,9 @@
 package luminosity
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,15 +18,25 @@ const (
 )
 
 type catalog struct {
-	Paths          []string        `json:"paths"`
-	Lenses         NamedObjectList `json:"lenses"`
-	Cameras        NamedObjectList `json:"cameras"`
-	Stats          *Stats          `json:"stats"`
-	Collections    []*Collection   `json:"collections"`
-	CollectionTree *Collection     `json:"collection_tree"`
-	Photos         []*PhotoRecord  `json:"-"`
+	Paths          []string          `json:"paths"`
+	Summaries      []*CatalogSummary `json:"catalogs"`
+	Lenses         NamedObjectList   `json:"lenses"`
+	Cameras        NamedObjectList   `json:"cameras"`
+	CameraBodies   CameraBodyList    `json:"camera_bodies"`
+	GearTimeline   *GearTimeline     `json:"gear_timeline"`
+	Stats          *Stats            `json:"stats"`
+	Collections    []*Collection     `json:"collections"`
+	CollectionTree *Collection       `json:"collection_tree"`
+	Photos         []*PhotoRecord    `json:"-"`
 }
 
+var (
+	// ErrorNoDatabase is returned by methods which read from the
+	// catalog database, when called on a catalog without a database
+	// connection, such as one created by NewCatalog for merging.
+	ErrorNoDatabase = fmt.Errorf("Catalog has no database connection")
+)
+
 // Catalog represents a Lightroom catalog and all the information
 // extracted from it.
 type Catalog struct {
@@ -35,6 +48,14 @@ type Catalog struct {
 	// Preview store for the cached Lightroom previews, if
 	// present. This is initialized lazily.
 	previews *CatalogPreviews
+
+	// StatsOptions controls how statistics are computed by
+	// GetStats. It must be set before the stats are first loaded.
+	StatsOptions StatsOptions
+
+	// run holds the data shared by the stats providers while GetStats
+	// is computing, and is nil otherwise.
+	run *statsRun
 }
 
 // NewCatalog allocates and initializes a new Catalog instance without
@@ -43,6 +64,25 @@ func NewCatalog() *Catalog {
 	return &Catalog{}
 }
 
+// LoadCatalogJSON reads a catalog previously written as JSON, such as
+// the output of the stats command. The returned catalog has no
+// database connection, and can only be merged into others. Photo
+// records are not preserved in the JSON.
+func LoadCatalogJSON(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCatalog()
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Error parsing catalog JSON %s: %s", path, err)
+	}
+	if len(c.Paths) == 0 {
+		c.Paths = []string{path}
+	}
+	return c, nil
+}
+
 // OpenCatalog initializes a new Catalog struct and opens a connection
 // to the database file, but does not load any data. OpenCatalog will
 // fail if the catalog is currently open in Lightroom.
@@ -69,6 +109,31 @@ func OpenCatalog(path string) (*Catalog, error) {
 	return cat, nil
 }
 
+// OpenCatalogReadOnly opens a catalog like OpenCatalog, but without
+// write access to the database file.
+func OpenCatalogReadOnly(path string) (*Catalog, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := OpenDBReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"action": "catalog_open",
+		"path":   path,
+		"mode":   "read_only",
+		"status": "ok",
+	}).Debug()
+	cat := &Catalog{
+		db: db,
+	}
+	cat.Paths = []string{
+		path,
+	}
+	return cat, nil
+}
+
 func (c *Catalog) Path() string {
 	return c.Paths[0]
 }
@@ -105,19 +170,29 @@ func (c *Catalog) Close() error {
 }
 
 // Load retrieves everything luminosity knows about the lightroom
-// catalog - lenses, cameras, statistics, and summary metadata for
-// every photo.
+// catalog - lenses, cameras, statistics and collections. Photo
+// records are not loaded; see GetPhotos and ForEachPhoto.
 func (c *Catalog) Load() error {
+	if c.Summaries == nil {
+		if s, err := c.GetSummary(); err != nil {
+			return err
+		} else {
+			c.Summaries = []*CatalogSummary{s}
+		}
+	}
 	if _, err := c.GetLenses(); err != nil {
 		return err
 	}
 	if _, err := c.GetCameras(); err != nil {
 		return err
 	}
-	if _, err := c.GetStats(); err != nil {
+	if _, err := c.GetCameraBodies(); err != nil {
+		return err
+	}
+	if _, err := c.GetGearTimeline(); err != nil {
 		return err
 	}
-	if _, err := c.GetPhotos(); err != nil {
+	if _, err := c.GetStats(); err != nil {
 		return err
 	}
 	if _, err := c.GetCollections(); err != nil {
@@ -131,7 +206,11 @@ func (c *Catalog) Load() error {
 
 // Merge takes the loaded contents of another catalog and merges them
 // into the target. Named objects are kept unique according to their
-// names.
+// names. If StatsOptions.AttributeCatalogs is set on the target, the
+// distribution entries of the merged stats keep a breakdown of their
+// counts by catalog name. Stats already merged from several catalogs
+// without attribution, e.g. read back by stats merge, cannot be split
+// between them, and are left unattributed.
 func (c *Catalog) Merge(other *Catalog) {
 	if other == nil {
 		return
@@ -139,13 +218,32 @@ func (c *Catalog) Merge(other *Catalog) {
 	if other.Paths != nil {
 		c.Paths = append(c.Paths, other.Paths...)
 	}
+	if other.Summaries != nil {
+		c.Summaries = append(c.Summaries, other.Summaries...)
+	}
 	if other.Stats != nil {
+		if c.StatsOptions.AttributeCatalogs && len(other.Paths) == 1 {
+			other.Stats.Attribute(other.Name())
+		}
 		stats, _ := c.GetStats()
 		stats.Merge(other.Stats)
+		stats.Sessions.Regroup(c.StatsOptions.TimeBasis, c.StatsOptions.Sessions)
+		if c.StatsOptions.FillEmptyBuckets {
+			stats.FillEmptyBuckets()
+		}
 	}
 	if other.Cameras != nil {
 		c.Cameras = c.Cameras.Merge(other.Cameras)
 	}
+	if other.CameraBodies != nil {
+		c.CameraBodies = c.CameraBodies.Merge(other.CameraBodies)
+	}
+	if other.GearTimeline != nil {
+		if c.GearTimeline == nil {
+			c.GearTimeline = &GearTimeline{}
+		}
+		c.GearTimeline.Merge(other.GearTimeline)
+	}
 	if other.Lenses != nil {
 		c.Lenses = c.Lenses.Merge(other.Lenses)
 	}
@@ -161,7 +259,7 @@ func (c *Catalog) Merge(other *Catalog) {
 }
 
 // GetLenses returns a list of every lens name extracted from EXIF
-// metadata by Lightroom.
+// metadata by Lightroom, canonicalized by StatsOptions.Names.
 func (c *Catalog) GetLenses() (NamedObjectList, error) {
 	if c.Lenses != nil {
 		return c.Lenses, nil
@@ -170,12 +268,12 @@ func (c *Catalog) GetLenses() (NamedObjectList, error) {
 	if err != nil {
 		return nil, err
 	}
-	c.Lenses = lenses
+	c.Lenses = normalizeNamedObjects(lenses, c.StatsOptions.Names.Lens)
 	return c.Lenses, nil
 }
 
 // GetCameras returns a list of every camera name extracted from EXIF
-// metadata by Lightroom.
+// metadata by Lightroom, canonicalized by StatsOptions.Names.
 func (c *Catalog) GetCameras() (NamedObjectList, error) {
 	if c.Cameras != nil {
 		return c.Cameras, nil
@@ -184,7 +282,7 @@ func (c *Catalog) GetCameras() (NamedObjectList, error) {
 	if err != nil {
 		return nil, err
 	}
-	c.Cameras = cameras
+	c.Cameras = normalizeNamedObjects(cameras, c.StatsOptions.Names.Camera)
 	return c.Cameras, nil
 }
 
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdBodies() *cobra.Command {
+	return &cobra.Command{
+		Use:   "bodies PATH...",
+		Short: "Report usage of each individual camera body",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var bodies luminosity.CameraBodyList
+			for _, path := range luminosity.FindCatalogs(args...) {
+				catalog, err := luminosity.OpenCatalog(path)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"action":  "catalog_open",
+						"catalog": path,
+						"error":   err,
+					}).Warn("Error opening catalog, skipping.")
+					continue
+				}
+
+				b, err := catalog.GetCameraBodies()
+				if err != nil {
+					log.WithFields(log.Fields{
+						"action":  "camera_bodies",
+						"catalog": path,
+						"error":   err,
+					}).Warn("Error getting camera bodies, skipping.")
+				} else {
+					bodies = bodies.Merge(b)
+				}
+				catalog.Close()
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "MAKE\tMODEL\tSERIAL\tPHOTOS\tEST. ACTUATIONS\tFIRST USED\tLAST USED\n")
+			for _, b := range bodies {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+					b.Make, b.Model, b.Serial, b.Count, b.EstimatedActuations,
+					b.FirstUsed.Format(luminosity.DayFormat),
+					b.LastUsed.Format(luminosity.DayFormat))
+			}
+			w.Flush()
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdStatsCompare() *cobra.Command {
+	var outfile string
+	var prettyPrint bool
+	var windows []string
+	var timeBasis string
+	var cropFactorFile string
+	var namesFile string
+	var excludeVideos bool
+	var topN int
+
+	cmd := &cobra.Command{
+		Use:   "compare [--windows BASELINE,CURRENT PATH...] | [BASELINE_PATH CURRENT_PATH]",
+		Short: "Compare the stats of two time windows or two catalogs",
+		Long: `
+Compare the distributions and keeper rates of two sets of photos.
+
+With --windows, the photos of every catalog found in PATH... are
+split into two date windows, each given as a year (2023), a month
+(2023-06), a day (2023-06-15) or an inclusive range of those
+(2023-01..2023-06). Without it, exactly two paths are compared, each
+of which may be a catalog or a directory of catalogs.
+`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "",
+		"Write the full comparison as JSON to this file")
+	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
+		"Format the JSON output indented for human readability")
+	cmd.Flags().StringSliceVarP(&windows, "windows", "w", nil,
+		"Baseline and current date windows to compare, e.g. 2023,2024")
+	cmd.Flags().StringVarP(&timeBasis, "time-basis", "", "local",
+		"Match windows against local capture time (local) or UTC (utc)")
+	cmd.Flags().StringVarP(&cropFactorFile, "crop-factors", "", "",
+		"JSON file mapping camera models to crop factors, supplementing the built-in table")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+	cmd.Flags().BoolVarP(&excludeVideos, "exclude-videos", "", false,
+		"Leave videos out of the comparison")
+	cmd.Flags().IntVarP(&topN, "top", "", 10,
+		"Number of cameras, lenses, keywords, etc... to list in the text report (0 for all)")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		basis, err := luminosity.ParseTimeBasis(timeBasis)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action":     "parse_flags",
+				"time_basis": timeBasis,
+				"error":      err,
+			}).Error("Invalid time basis")
+			return
+		}
+		options := luminosity.StatsOptions{
+			TimeBasis:     basis,
+			ExcludeVideos: excludeVideos,
+		}
+		if cropFactorFile != "" {
+			crops, err := luminosity.LoadCropFactors(cropFactorFile)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action": "load_crop_factors",
+					"file":   cropFactorFile,
+					"error":  err,
+				}).Error("Error loading crop factors")
+				return
+			}
+			options.CropFactors = crops
+		}
+		if options.Names, err = loadNames(namesFile); err != nil {
+			return
+		}
+
+		var cmp *luminosity.StatsComparison
+		if len(windows) > 0 {
+			if len(windows) != 2 {
+				log.WithFields(log.Fields{
+					"action":  "parse_flags",
+					"windows": windows,
+				}).Error("Exactly two windows are required")
+				return
+			}
+			var stats [2]*luminosity.Stats
+			for i, name := range windows {
+				w, err := luminosity.ParseDateWindow(name)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"action": "parse_flags",
+						"window": name,
+						"error":  err,
+					}).Error("Invalid date window")
+					return
+				}
+				opts := options
+				opts.Window = w
+				stats[i] = loadStats(opts, args...)
+			}
+			cmp = luminosity.CompareStats(windows[0], stats[0], windows[1], stats[1])
+		} else {
+			if len(args) != 2 {
+				log.WithFields(log.Fields{
+					"action": "parse_args",
+					"paths":  args,
+				}).Error("Exactly two paths are required when not comparing windows")
+				return
+			}
+			cmp = luminosity.CompareStats(args[0], loadStats(options, args[0]),
+				args[1], loadStats(options, args[1]))
+		}
+
+		if outfile != "" {
+			write(outfile, cmp, prettyPrint)
+		}
+		printComparison(cmp, topN)
+	}
+
+	return cmd
+}
+
+// loadStats computes the stats of every catalog found in paths with
+// the given options, and returns them merged.
+func loadStats(options luminosity.StatsOptions, paths ...string) *luminosity.Stats {
+	merged := luminosity.NewCatalog()
+	merged.StatsOptions = options
+	for _, path := range luminosity.FindCatalogs(paths...) {
+		c, err := luminosity.OpenCatalog(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action":  "catalog_open",
+				"catalog": path,
+				"error":   err,
+			}).Warn("Error opening catalog, skipping")
+			continue
+		}
+		c.StatsOptions = options
+		if _, err := c.GetStats(); err != nil {
+			log.WithFields(log.Fields{
+				"action":  "catalog_stats",
+				"catalog": path,
+				"error":   err,
+			}).Warn("Error getting catalog stats, skipping")
+		} else {
+			merged.Merge(c)
+		}
+		c.Close()
+	}
+	stats, _ := merged.GetStats()
+	return stats
+}
+
+// printComparison writes a text report of a comparison to stdout,
+// listing at most topN entries of the unordered distributions.
+func printComparison(cmp *luminosity.StatsComparison, topN int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\tCHANGE\t\n", cmp.Baseline, cmp.Current)
+	fmt.Fprintf(w, "PHOTOS\t%d\t%d\t%+d\t\n", cmp.BaselinePhotos, cmp.CurrentPhotos,
+		cmp.CurrentPhotos-cmp.BaselinePhotos)
+	k := cmp.KeeperRates.Overall
+	fmt.Fprintf(w, "KEEPER RATE\t%.1f%%\t%.1f%%\t%+.1f pts\t\n",
+		100*k.Baseline.Rate, 100*k.Current.Rate, 100*k.Delta)
+	fmt.Fprintf(w, "\t\t\t\t\n")
+
+	for i, d := range cmp.Distributions {
+		if len(d.Entries) == 0 {
+			continue
+		}
+		entries := d.Entries
+		if topN > 0 && !luminosity.ComparedDistributions[i].Ordered && len(entries) > topN {
+			entries = entries[:topN]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\tSHARE CHANGE\t\n",
+			strings.ToUpper(strings.Replace(d.Name, "_", " ", -1)), cmp.Baseline, cmp.Current)
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%d (%.1f%%)\t%d (%.1f%%)\t%+.1f pts\t\n",
+				e.Label, e.Baseline, e.BaselineShare, e.Current, e.CurrentShare, e.ShareDelta)
+		}
+		if len(d.Added) > 0 {
+			fmt.Fprintf(w, "new: %s\n", strings.Join(d.Added, ", "))
+		}
+		if len(d.Dropped) > 0 {
+			fmt.Fprintf(w, "dropped: %s\n", strings.Join(d.Dropped, ", "))
+		}
+		fmt.Fprintf(w, "\t\t\t\t\n")
+	}
+
+	printKeepers := func(title string, deltas []*luminosity.KeeperRateDelta) {
+		fmt.Fprintf(w, "%s\t%s\t%s\tCHANGE\t\n", title, cmp.Baseline, cmp.Current)
+		for _, k := range deltas {
+			fmt.Fprintf(w, "%s\t%.1f%% of %d\t%.1f%% of %d\t%+.1f pts\t\n", k.Label,
+				100*k.Baseline.Rate, k.Baseline.Photos, 100*k.Current.Rate, k.Current.Photos, 100*k.Delta)
+		}
+		fmt.Fprintf(w, "\t\t\t\t\n")
+	}
+	printKeepers("KEEPER RATE BY CAMERA", cmp.KeeperRates.ByCamera)
+	printKeepers("KEEPER RATE BY LENS", cmp.KeeperRates.ByLens)
+	w.Flush()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdExport() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export catalog data",
+	}
+	cmd.AddCommand(CmdExportPhotos())
+	return cmd
+}
+
+func CmdExportPhotos() *cobra.Command {
+	var outfile string
+	var format string
+	var force bool
+	var window string
+	var timeBasis string
+	var cropFactorFile string
+	var namesFile string
+	var excludeVideos bool
+
+	cmd := &cobra.Command{
+		Use:   "photos [--format csv|tsv|ndjson|sqlite] [--outfile] PATH...",
+		Short: "Export the metadata of every photo, with keywords, collections and catalog of origin",
+		Args:  cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "-",
+		"Path to output file, or - for stdout (not supported by sqlite)")
+	cmd.Flags().StringVarP(&format, "format", "f", "csv",
+		"Output format (csv, tsv, ndjson, sqlite)")
+	cmd.Flags().BoolVarP(&force, "force", "", false,
+		"Replace the output file of a sqlite export if it exists")
+	cmd.Flags().StringVarP(&window, "window", "w", "",
+		"Only export photos captured in a date window, e.g. 2023, 2023-06 or 2023-01..2023-06")
+	cmd.Flags().StringVarP(&timeBasis, "time-basis", "", "local",
+		"Match the window against local capture time (local) or UTC (utc)")
+	cmd.Flags().StringVarP(&cropFactorFile, "crop-factors", "", "",
+		"JSON file mapping camera models to crop factors, supplementing the built-in table")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+	cmd.Flags().BoolVarP(&excludeVideos, "exclude-videos", "", false,
+		"Leave videos out of the export")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		basis, err := luminosity.ParseTimeBasis(timeBasis)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action":     "parse_flags",
+				"time_basis": timeBasis,
+				"error":      err,
+			}).Error("Invalid time basis")
+			return
+		}
+		options := luminosity.StatsOptions{
+			TimeBasis:     basis,
+			ExcludeVideos: excludeVideos,
+		}
+		if window != "" {
+			if options.Window, err = luminosity.ParseDateWindow(window); err != nil {
+				log.WithFields(log.Fields{
+					"action": "parse_flags",
+					"window": window,
+					"error":  err,
+				}).Error("Invalid date window")
+				return
+			}
+		}
+		if cropFactorFile != "" {
+			crops, err := luminosity.LoadCropFactors(cropFactorFile)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action": "load_crop_factors",
+					"file":   cropFactorFile,
+					"error":  err,
+				}).Error("Error loading crop factors")
+				return
+			}
+			options.CropFactors = crops
+		}
+		if options.Names, err = loadNames(namesFile); err != nil {
+			return
+		}
+
+		exporter, closeOutput, err := newPhotoExporter(format, outfile, force)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action": "export_open",
+				"format": format,
+				"file":   outfile,
+				"error":  err,
+			}).Error("Error opening export output")
+			return
+		}
+
+		var total int
+		for _, path := range luminosity.FindCatalogs(args...) {
+			c, err := luminosity.OpenCatalog(path)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "catalog_open",
+					"catalog": path,
+					"error":   err,
+				}).Warn("Error opening catalog, skipping")
+				continue
+			}
+			c.StatsOptions = options
+			err = c.ExportPhotos(exporter)
+			c.Close()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "export_photos",
+					"catalog": path,
+					"error":   err,
+				}).Fatal("Error exporting photos")
+			}
+			total++
+			log.WithFields(log.Fields{
+				"action":  "export_photos",
+				"catalog": path,
+				"status":  "ok",
+			}).Info("Exported catalog")
+		}
+
+		if err := exporter.Close(); err == nil {
+			err = closeOutput()
+		} else {
+			closeOutput()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action": "export_close",
+				"file":   outfile,
+				"error":  err,
+			}).Fatal("Error writing export")
+		}
+
+		log.WithFields(log.Fields{
+			"action":             "status",
+			"status":             "complete",
+			"catalogs_processed": total,
+		}).Info("Complete")
+	}
+
+	return cmd
+}
+
+// newPhotoExporter returns an exporter for the named format writing to
+// path, and a function closing the output file.
+func newPhotoExporter(format, path string, force bool) (luminosity.PhotoExporter, func() error, error) {
+	noop := func() error { return nil }
+	if format == "sqlite" {
+		if path == "-" {
+			return nil, nil, fmt.Errorf("The sqlite format cannot be written to stdout")
+		}
+		if strings.HasSuffix(path, luminosity.CatalogExtension) {
+			return nil, nil, fmt.Errorf("Refusing to write an export to a Lightroom catalog path")
+		}
+		if force {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, nil, err
+			}
+		}
+		exporter, err := luminosity.NewSQLitePhotoExporter(path)
+		return exporter, noop, err
+	}
+
+	if format != "csv" && format != "tsv" && format != "ndjson" {
+		return nil, nil, fmt.Errorf("Unknown export format %q", format)
+	}
+	w := os.Stdout
+	closer := noop
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f.Close
+	}
+	switch format {
+	case "csv":
+		return luminosity.NewCSVPhotoExporter(w, ','), closer, nil
+	case "tsv":
+		return luminosity.NewCSVPhotoExporter(w, '\t'), closer, nil
+	default:
+		return luminosity.NewNDJSONPhotoExporter(w), closer, nil
+	}
+}
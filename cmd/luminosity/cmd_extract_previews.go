@@ -12,6 +12,7 @@ import (
 
 func CmdExtractPreviews() *cobra.Command {
 	var outdir string
+	var excludeVideos bool
 
 	cmd := &cobra.Command{
 		Use:   "extract PATH",
@@ -21,6 +22,8 @@ func CmdExtractPreviews() *cobra.Command {
 
 	cmd.Flags().StringVarP(&outdir, "output-dir", "o", "previews",
 		"Directory to write extracted previews to")
+	cmd.Flags().BoolVarP(&excludeVideos, "exclude-videos", "", false,
+		"Skip the poster frames of videos")
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
 		path := args[0]
@@ -34,6 +37,7 @@ func CmdExtractPreviews() *cobra.Command {
 			return
 		}
 		defer catalog.Close()
+		catalog.StatsOptions.ExcludeVideos = excludeVideos
 
 		// Ensure outdir exists and is a directory
 		fi, err := os.Stat(outdir)
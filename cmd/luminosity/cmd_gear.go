@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdGear() *cobra.Command {
+	var outfile string
+	var prettyPrint bool
+	var unusedMonths int
+	var namesFile string
+
+	cmd := &cobra.Command{
+		Use:   "gear PATH...",
+		Short: "Report camera and lens usage over time",
+		Args:  cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "",
+		"Write the full gear timeline as JSON to this file")
+	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
+		"Format the JSON output indented for human readability")
+	cmd.Flags().IntVarP(&unusedMonths, "unused-months", "u", 12,
+		"List lenses and cameras not used in this many months")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		names, err := loadNames(namesFile)
+		if err != nil {
+			return
+		}
+
+		timeline := &luminosity.GearTimeline{}
+		for _, path := range luminosity.FindCatalogs(args...) {
+			catalog, err := luminosity.OpenCatalog(path)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "catalog_open",
+					"catalog": path,
+					"error":   err,
+				}).Warn("Error opening catalog, skipping.")
+				continue
+			}
+			catalog.StatsOptions.Names = names
+
+			t, err := catalog.GetGearTimeline()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "gear_timeline",
+					"catalog": path,
+					"error":   err,
+				}).Warn("Error getting gear timeline, skipping.")
+			} else {
+				timeline.Merge(t)
+			}
+			catalog.Close()
+		}
+
+		if outfile != "" {
+			write(outfile, timeline, prettyPrint)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		printGear := func(title string, gear luminosity.GearUsageList) {
+			fmt.Fprintf(w, "%s\tPHOTOS\tFIRST USED\tLAST USED\n", title)
+			for _, g := range gear {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", g.Name, g.Count,
+					g.FirstUsed.Format(luminosity.DayFormat),
+					g.LastUsed.Format(luminosity.DayFormat))
+			}
+			fmt.Fprintf(w, "\t\t\t\n")
+		}
+		printGear("CAMERA", timeline.Cameras)
+		printGear("LENS", timeline.Lenses)
+
+		cutoff := time.Now().AddDate(0, -unusedMonths, 0)
+		fmt.Fprintf(w, "NOT USED IN %d MONTHS\tPHOTOS\tFIRST USED\tLAST USED\n", unusedMonths)
+		unused := append(timeline.Lenses.UnusedSince(cutoff), timeline.Cameras.UnusedSince(cutoff)...)
+		for _, g := range unused {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", g.Name, g.Count,
+				g.FirstUsed.Format(luminosity.DayFormat),
+				g.LastUsed.Format(luminosity.DayFormat))
+		}
+		w.Flush()
+	}
+
+	return cmd
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdStatsMerge() *cobra.Command {
+	var outfile string
+	var prettyPrint bool
+	var formatName string
+	var split bool
+	var fillEmpty bool
+	var histograms bool
+	var topN int
+	var byCatalog bool
+	var sessionGap, tripGap time.Duration
+	var tripDistance float64
+
+	cmd := &cobra.Command{
+		Use:   "merge [--outfile] [--format] [--pretty-print] JSON_FILE...",
+		Short: "Merge previously generated stats JSON files without reopening the catalogs",
+		Args:  cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "stats.json",
+		"Path to output file, or - for stdout")
+	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
+		"Format the JSON output indented for human readability")
+	cmd.Flags().StringVarP(&formatName, "format", "f", "json",
+		"Output format ("+formatNames()+")")
+	cmd.Flags().BoolVarP(&split, "split", "", false,
+		"Write each distribution to its own file, in the --outfile directory")
+	cmd.Flags().BoolVarP(&fillEmpty, "fill-empty", "", false,
+		"Emit zero count entries for empty dates and time buckets")
+	cmd.Flags().BoolVarP(&histograms, "histograms", "", false,
+		"Include distributions binned into standard photographic ranges and stops")
+	cmd.Flags().IntVarP(&topN, "top", "", 0,
+		"Include the top N lenses, cameras and keywords in the histograms")
+	cmd.Flags().BoolVarP(&byCatalog, "by-catalog", "", false,
+		"Break down every distribution entry by the catalog it came from")
+	cmd.Flags().DurationVarP(&sessionGap, "session-gap", "", luminosity.DefaultSessionGap,
+		"Longest interval between two photos of the same shooting session")
+	cmd.Flags().DurationVarP(&tripGap, "trip-gap", "", luminosity.DefaultTripGap,
+		"Longest interval between two sessions of the same trip")
+	cmd.Flags().Float64VarP(&tripDistance, "trip-distance", "", luminosity.DefaultTripDistance,
+		"Greatest distance in km between two sessions of the same trip, when GPS coordinates are available")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		format, err := parseFormat(formatName)
+		if err != nil {
+			return
+		}
+		if !cmd.Flags().Changed("outfile") {
+			outfile = statsOutfile("stats", format, split)
+		}
+
+		merged := luminosity.NewCatalog()
+		merged.StatsOptions = luminosity.StatsOptions{
+			FillEmptyBuckets:  fillEmpty,
+			AttributeCatalogs: byCatalog,
+			Sessions: luminosity.SessionOptions{
+				SessionGap:   sessionGap,
+				TripGap:      tripGap,
+				TripDistance: tripDistance,
+			},
+		}
+
+		var total int
+		for _, path := range args {
+			c, err := luminosity.LoadCatalogJSON(path)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action": "load_json",
+					"file":   path,
+					"error":  err,
+				}).Warn("Error loading stats JSON, skipping")
+				continue
+			}
+			merged.Merge(c)
+			total++
+			log.WithFields(log.Fields{
+				"action": "merge_json",
+				"file":   path,
+				"status": "ok",
+			}).Info("Merged stats JSON")
+		}
+
+		stats, _ := merged.GetStats()
+		if histograms {
+			stats.Histograms = stats.StandardHistograms(topN)
+		}
+		writeStats(outfile, format, merged, split, prettyPrint)
+
+		log.WithFields(log.Fields{
+			"action":          "status",
+			"status":          "complete",
+			"files_processed": total,
+		}).Info("Complete")
+	}
+
+	return cmd
+}
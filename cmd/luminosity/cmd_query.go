@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdQuery() *cobra.Command {
+	var outfile string
+	var format string
+	var listViews bool
+	var prettyPrint bool
+
+	cmd := &cobra.Command{
+		Use:   "query [--format] [--outfile] PATH... SQL",
+		Short: "Run a read-only SQL query against one or more catalogs",
+		Long: `Run a read-only SQL query against one or more catalogs.
+
+The catalogs are opened read-only, with temporary views giving
+friendlier access to the Lightroom schema (see --list-views). When
+querying several catalogs, a catalog column is added to the results.`,
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "-",
+		"Path to output file, or - for stdout")
+	cmd.Flags().StringVarP(&format, "format", "f", "text",
+		"Output format (text, "+formatNames()+")")
+	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
+		"Format the JSON output indented for human readability")
+	cmd.Flags().BoolVarP(&listViews, "list-views", "", false,
+		"List the views available to queries and exit")
+
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		if listViews {
+			return nil
+		}
+		return cobra.MinimumNArgs(2)(cmd, args)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		var err error
+		if listViews {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, v := range luminosity.QueryViews {
+				fmt.Fprintf(tw, "%s\t%s\n", v.Name, v.Description)
+			}
+			tw.Flush()
+			return
+		}
+		var outputFormat luminosity.OutputFormat
+		if format != "text" {
+			if outputFormat, err = parseFormat(format); err != nil {
+				return
+			}
+		}
+
+		query := args[len(args)-1]
+		paths := luminosity.FindCatalogs(args[:len(args)-1]...)
+		result := &luminosity.QueryResult{}
+		for _, path := range paths {
+			c, err := luminosity.OpenQueryCatalog(path)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "catalog_open",
+					"catalog": path,
+					"error":   err,
+				}).Warn("Error opening catalog, skipping")
+				continue
+			}
+			r, err := c.Query(query)
+			c.Close()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "query",
+					"catalog": path,
+					"error":   err,
+				}).Fatal("Error running query")
+			}
+			if len(paths) > 1 {
+				r = r.WithCatalogColumn(c.Name())
+			}
+			if err := result.Append(r); err != nil {
+				log.WithFields(log.Fields{
+					"action":  "query",
+					"catalog": path,
+					"error":   err,
+				}).Fatal("Error combining query results")
+			}
+		}
+
+		if format == "text" {
+			writeText(outfile, result)
+		} else {
+			output(outfile, outputFormat, result, prettyPrint)
+		}
+	}
+
+	return cmd
+}
+
+// writeText writes a query result as aligned text to path, or stdout
+// if path is "-". Errors are fatal.
+func writeText(path string, result *luminosity.QueryResult) {
+	w := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action": "write",
+				"file":   path,
+				"error":  err,
+			}).Fatal("Error writing output")
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := result.WriteText(w); err != nil {
+		log.WithFields(log.Fields{
+			"action": "write",
+			"file":   path,
+			"error":  err,
+		}).Fatal("Error writing output")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aalpern/luminosity"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func CmdSearch() *cobra.Command {
+	var outfile string
+	var format string
+	var prettyPrint bool
+	var limit int
+	var refresh bool
+	var namesFile string
+
+	cmd := &cobra.Command{
+		Use:   "search [--limit] [--format] PATH... QUERY",
+		Short: "Full-text search of captions, titles, keywords, collections and file names across catalogs",
+		Long: `Full-text search of captions, titles, headlines, keywords, collection
+names, file base names and folder paths across one or more catalogs.
+
+The query uses the SQLite full-text syntax, e.g. beach sunset,
+"family dinner", port* or beach OR lake. Each catalog is indexed in a
+file next to it, which is rebuilt whenever the catalog has changed;
+the catalog itself is only read.
+
+Scores are relative to the best match of each catalog, so that the
+results of several catalogs are ranked together on the same scale.`,
+		Args: cobra.MinimumNArgs(2),
+	}
+
+	cmd.Flags().StringVarP(&outfile, "outfile", "o", "-",
+		"Path to output file, or - for stdout")
+	cmd.Flags().StringVarP(&format, "format", "f", "text",
+		"Output format (text, json, ndjson)")
+	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
+		"Format the JSON output indented for human readability")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20,
+		"Maximum number of results, or 0 for all")
+	cmd.Flags().BoolVarP(&refresh, "refresh", "", false,
+		"Rebuild the search indexes even if they are up to date")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		var outputFormat luminosity.OutputFormat
+		if format != "text" {
+			f, err := parseFormat(format)
+			if err != nil {
+				return
+			}
+			if f.IsTabular() {
+				log.WithFields(log.Fields{
+					"action": "parse_flags",
+					"format": format,
+				}).Error("Search results can only be written as text, json or ndjson")
+				return
+			}
+			outputFormat = f
+		}
+		names, err := loadNames(namesFile)
+		if err != nil {
+			return
+		}
+
+		query := args[len(args)-1]
+		options := luminosity.SearchOptions{
+			Limit:   limit,
+			Refresh: refresh,
+		}
+		var results []*luminosity.SearchResult
+		for _, path := range luminosity.FindCatalogs(args[:len(args)-1]...) {
+			c, err := luminosity.OpenCatalogReadOnly(path)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "catalog_open",
+					"catalog": path,
+					"error":   err,
+				}).Warn("Error opening catalog, skipping")
+				continue
+			}
+			c.StatsOptions.Names = names
+			r, err := c.Search(query, options)
+			c.Close()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":  "search",
+					"catalog": path,
+					"error":   err,
+				}).Fatal("Error searching catalog")
+			}
+			results = append(results, r...)
+		}
+		results = luminosity.RankSearchResults(results, limit)
+
+		if format != "text" {
+			output(outfile, outputFormat, results, prettyPrint)
+			return
+		}
+		w := os.Stdout
+		if outfile != "-" {
+			f, err := os.Create(outfile)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action": "write",
+					"file":   outfile,
+					"error":  err,
+				}).Fatal("Error writing output")
+			}
+			defer f.Close()
+			w = f
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SCORE\tCATALOG\tFILE\tCAPTION")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%.4g\t%s\t%s\t%s\n", r.Score, r.CatalogName, r.FullName,
+				strings.Replace(r.Caption.String, "\n", " ", -1))
+		}
+		tw.Flush()
+	}
+
+	return cmd
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aalpern/luminosity"
 	log "github.com/sirupsen/logrus"
@@ -13,53 +14,194 @@ func CmdStats() *cobra.Command {
 	var outfile string
 	var perCatalog bool
 	var prettyPrint bool
+	var formatName string
+	var split bool
+	var timeBasis string
+	var timeBuckets []string
+	var fillEmpty bool
+	var histograms bool
+	var topN int
+	var cropFactorFile string
+	var namesFile string
+	var excludeVideos bool
+	var byCatalog bool
+	var sessionGap, tripGap time.Duration
+	var tripDistance float64
+	var sequenceWindow, burstInterval time.Duration
+	var noCache, refresh bool
+	var dimensions, excludeDimensions []string
+	var cacheDir string
 
 	cmd := &cobra.Command{
 		Use:   "stats PATH...",
 		Short: "Generate catalog statistics",
 		Args:  cobra.MinimumNArgs(1),
 	}
+	cmd.AddCommand(CmdStatsCompare(), CmdStatsMerge())
 
 	cmd.Flags().StringVarP(&outfile, "outfile", "o", "stats.json",
-		"Path to output file")
+		"Path to output file, or - for stdout")
 	cmd.Flags().BoolVarP(&perCatalog, "per-catalog", "c", false,
 		"Output a summary .json file for each catalog, in addition to the merged output")
 	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
 		"Format the JSON output indented for human readability")
+	cmd.Flags().StringVarP(&formatName, "format", "f", "json",
+		"Output format ("+formatNames()+")")
+	cmd.Flags().BoolVarP(&split, "split", "", false,
+		"Write each distribution to its own file, in the --outfile directory")
+	cmd.Flags().StringVarP(&timeBasis, "time-basis", "", "local",
+		"Bucket dates by local capture time (local) or by UTC (utc)")
+	cmd.Flags().StringSliceVarP(&timeBuckets, "time-buckets", "", nil,
+		"Additional time buckets to compute (hour, day, week, month, year, weekday, hour_of_day, weekday_hour)")
+	cmd.Flags().BoolVarP(&fillEmpty, "fill-empty", "", false,
+		"Emit zero count entries for empty dates and time buckets")
+	cmd.Flags().BoolVarP(&histograms, "histograms", "", false,
+		"Include distributions binned into standard photographic ranges and stops")
+	cmd.Flags().IntVarP(&topN, "top", "", 0,
+		"Include the top N lenses, cameras and keywords in the histograms")
+	cmd.Flags().StringVarP(&cropFactorFile, "crop-factors", "", "",
+		"JSON file mapping camera models to crop factors, supplementing the built-in table")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+	cmd.Flags().DurationVarP(&sessionGap, "session-gap", "", luminosity.DefaultSessionGap,
+		"Longest interval between two photos of the same shooting session")
+	cmd.Flags().DurationVarP(&tripGap, "trip-gap", "", luminosity.DefaultTripGap,
+		"Longest interval between two sessions of the same trip")
+	cmd.Flags().Float64VarP(&tripDistance, "trip-distance", "", luminosity.DefaultTripDistance,
+		"Greatest distance in km between two sessions of the same trip, when GPS coordinates are available")
+	cmd.Flags().DurationVarP(&sequenceWindow, "sequence-window", "", luminosity.DefaultSequenceWindow,
+		"Longest interval between two frames of a burst, bracket or focus stack")
+	cmd.Flags().DurationVarP(&burstInterval, "burst-interval", "", luminosity.DefaultBurstInterval,
+		"Longest mean interval between the frames of a burst; slower sequences are focus stacks")
+	cmd.Flags().BoolVarP(&byCatalog, "by-catalog", "", false,
+		"Break down every distribution entry by the catalog it came from")
+	cmd.Flags().BoolVarP(&excludeVideos, "exclude-videos", "", false,
+		"Leave videos out of the distributions (they are still counted in the video stats)")
+	cmd.Flags().StringSliceVarP(&dimensions, "dimensions", "", nil,
+		"Distributions to compute, skipping all others ("+strings.Join(luminosity.StatsProviderNames(), ", ")+")")
+	cmd.Flags().StringSliceVarP(&excludeDimensions, "exclude-dimensions", "", nil,
+		"Distributions to skip, such as expensive ones")
+	cmd.Flags().BoolVarP(&noCache, "no-cache", "", false,
+		"Load every catalog, without reading or updating the catalog cache")
+	cmd.Flags().BoolVarP(&refresh, "refresh", "", false,
+		"Load every catalog and update the catalog cache, ignoring existing entries")
+	cmd.Flags().StringVarP(&cacheDir, "cache-dir", "", "",
+		"Directory of the catalog cache (defaults to luminosity in the user cache directory)")
 
 	// paths := cmd.StringsArg("PATH", nil,
 	// "Paths to process, which can be .lrcat files or directories")
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
+		format, err := parseFormat(formatName)
+		if err != nil {
+			return
+		}
+		if !cmd.Flags().Changed("outfile") {
+			outfile = statsOutfile("stats", format, split)
+		}
+
+		basis, err := luminosity.ParseTimeBasis(timeBasis)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action":     "parse_flags",
+				"time_basis": timeBasis,
+				"error":      err,
+			}).Error("Invalid time basis")
+			return
+		}
+
+		options := luminosity.StatsOptions{
+			TimeBasis:            basis,
+			FillEmptyBuckets:     fillEmpty,
+			ExcludeVideos:        excludeVideos,
+			AttributeCatalogs:    byCatalog,
+			Distributions:        dimensions,
+			ExcludeDistributions: excludeDimensions,
+			Sessions: luminosity.SessionOptions{
+				SessionGap:   sessionGap,
+				TripGap:      tripGap,
+				TripDistance: tripDistance,
+			},
+			Sequences: luminosity.SequenceOptions{
+				Window:        sequenceWindow,
+				BurstInterval: burstInterval,
+			},
+		}
+		for _, name := range append(append([]string{}, dimensions...), excludeDimensions...) {
+			if luminosity.FindStatsProvider(name) == nil {
+				log.WithFields(log.Fields{
+					"action":    "parse_flags",
+					"dimension": name,
+				}).Error("Unknown dimension")
+				return
+			}
+		}
+
+		for _, name := range timeBuckets {
+			bucket, err := luminosity.ParseTimeBucket(name)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":      "parse_flags",
+					"time_bucket": name,
+					"error":       err,
+				}).Error("Invalid time bucket")
+				return
+			}
+			options.TimeBuckets = append(options.TimeBuckets, bucket)
+		}
+
+		if cropFactorFile != "" {
+			crops, err := luminosity.LoadCropFactors(cropFactorFile)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action": "load_crop_factors",
+					"file":   cropFactorFile,
+					"error":  err,
+				}).Error("Error loading crop factors")
+				return
+			}
+			options.CropFactors = crops
+		}
+
+		if options.Names, err = loadNames(namesFile); err != nil {
+			return
+		}
+
 		merged := luminosity.NewCatalog()
+		merged.StatsOptions = options
 		catalogPaths := luminosity.FindCatalogs((args)...)
 		var total int
 
-		for _, path := range catalogPaths {
-			c, err := luminosity.OpenCatalog(path)
+		var cache *luminosity.CatalogCache
+		if !noCache {
+			if cacheDir == "" {
+				cacheDir, err = luminosity.DefaultCatalogCacheDir()
+			}
+			if err == nil {
+				cache, err = luminosity.OpenCatalogCache(cacheDir)
+			}
 			if err != nil {
 				log.WithFields(log.Fields{
-					"action":  "catalog_open",
-					"catalog": path,
-					"error":   err,
-				}).Warn("Error opening catalog, skipping")
-				continue
+					"action": "cache_open",
+					"dir":    cacheDir,
+					"error":  err,
+				}).Warn("Error opening catalog cache, continuing without it")
 			}
+		}
 
-			err = c.Load()
+		for _, path := range catalogPaths {
+			c, err := loadCatalog(path, options, cache, refresh)
 			if err != nil {
-				log.WithFields(log.Fields{
-					"action":  "catalog_load",
-					"catalog": path,
-					"error":   err,
-				}).Warn("Error loading catalog, skipping")
-				c.Close()
 				continue
 			}
 
+			if histograms {
+				c.Stats.Histograms = c.Stats.StandardHistograms(topN)
+			}
+
 			if perCatalog {
-				jsPath := strings.Replace(filepath.Base(path), ".lrcat", ".json", 1)
-				write(jsPath, c, prettyPrint)
+				name := strings.TrimSuffix(filepath.Base(path), luminosity.CatalogExtension)
+				writeStats(statsOutfile(name, format, split), format, c, split, prettyPrint)
 			}
 
 			total++
@@ -73,7 +215,21 @@ func CmdStats() *cobra.Command {
 			c.Close()
 		}
 
-		write(outfile, merged, prettyPrint)
+		stats, _ := merged.GetStats()
+		for _, camera := range stats.UnknownCropFactorCameras {
+			log.WithFields(log.Fields{
+				"action": "crop_factor",
+				"status": "unknown",
+				"camera": camera.Label,
+				"count":  camera.Count,
+			}).Warn("Unknown crop factor, excluded from equivalent focal lengths")
+		}
+
+		if histograms {
+			stats.Histograms = stats.StandardHistograms(topN)
+		}
+
+		writeStats(outfile, format, merged, split, prettyPrint)
 
 		log.WithFields(log.Fields{
 			"action":             "status",
@@ -84,3 +240,51 @@ func CmdStats() *cobra.Command {
 
 	return cmd
 }
+
+// loadCatalog returns the loaded catalog at path, from the cache if it
+// has not changed since it was cached, unless refresh is set. Catalogs
+// loaded from disk are added to the cache. Errors are logged.
+func loadCatalog(path string, options luminosity.StatsOptions, cache *luminosity.CatalogCache, refresh bool) (*luminosity.Catalog, error) {
+	if cache != nil && !refresh {
+		if c, ok := cache.Get(path, options); ok {
+			log.WithFields(log.Fields{
+				"action":  "catalog_load",
+				"catalog": path,
+				"status":  "cached",
+			}).Info("Loaded catalog from cache")
+			return c, nil
+		}
+	}
+
+	c, err := luminosity.OpenCatalog(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"action":  "catalog_open",
+			"catalog": path,
+			"error":   err,
+		}).Warn("Error opening catalog, skipping")
+		return nil, err
+	}
+	c.StatsOptions = options
+
+	if err := c.Load(); err != nil {
+		log.WithFields(log.Fields{
+			"action":  "catalog_load",
+			"catalog": path,
+			"error":   err,
+		}).Warn("Error loading catalog, skipping")
+		c.Close()
+		return nil, err
+	}
+
+	if cache != nil {
+		if err := cache.Put(c); err != nil {
+			log.WithFields(log.Fields{
+				"action":  "cache_put",
+				"catalog": path,
+				"error":   err,
+			}).Warn("Error caching catalog")
+		}
+	}
+	return c, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/aalpern/luminosity"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -9,34 +11,73 @@ import (
 func CmdSunburst() *cobra.Command {
 	var outfile string
 	var prettyPrint bool
+	var namesFile string
+	var groupBy []string
+	var minCount int64
+	var flat bool
+	var excludeVideos bool
+	var includeUnknown bool
 
 	cmd := &cobra.Command{
-		Use:   "sunburst [--outfile] [--pretty-print] CATALOG",
+		Use:   "sunburst [--outfile] [--pretty-print] [--group-by DIMENSION,...] PATH...",
 		Short: "Generate stats for rendering sunburst graphs",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			catalog := args[0]
-			cat, err := luminosity.OpenCatalog(catalog)
+			dims, err := luminosity.ParseDimensions(groupBy)
 			if err != nil {
 				log.WithFields(log.Fields{
-					"action":  "catalog_open",
-					"catalog": catalog,
-					"error":   err,
-				}).Error("Error opening catalog, skipping.")
+					"action":   "parse_flags",
+					"group_by": groupBy,
+					"error":    err,
+				}).Error("Invalid group-by dimension")
 				return
 			}
 
-			data, err := cat.GetSunburstStats()
+			names, err := loadNames(namesFile)
 			if err != nil {
-				log.WithFields(log.Fields{
-					"action":  "sunburst_stats",
-					"catalog": catalog,
-					"error":   err,
-				}).Error("Error getting sunburst stats")
 				return
 			}
 
-			write(outfile, data, prettyPrint)
+			grouping := &luminosity.Grouping{Dimensions: dims}
+			for _, path := range luminosity.FindCatalogs(args...) {
+				cat, err := luminosity.OpenCatalog(path)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"action":  "catalog_open",
+						"catalog": path,
+						"error":   err,
+					}).Warn("Error opening catalog, skipping.")
+					continue
+				}
+				cat.StatsOptions.Names = names
+				cat.StatsOptions.ExcludeVideos = excludeVideos
+
+				g, err := cat.GroupBy(dims...)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"action":  "sunburst_stats",
+						"catalog": path,
+						"error":   err,
+					}).Warn("Error getting sunburst stats, skipping.")
+				} else {
+					grouping.Merge(g)
+				}
+				cat.Close()
+			}
+
+			if !includeUnknown {
+				grouping.DropUnknown(luminosity.DimensionCamera, luminosity.DimensionLens)
+			}
+
+			if flat {
+				write(outfile, grouping.ToMaps(), prettyPrint)
+				return
+			}
+			tree := grouping.Tree("Photos")
+			if minCount > 0 {
+				tree.Prune(minCount)
+			}
+			write(outfile, tree, prettyPrint)
 		},
 	}
 
@@ -44,6 +85,26 @@ func CmdSunburst() *cobra.Command {
 		"Output file for sunburst chart JSON data")
 	cmd.Flags().BoolVarP(&prettyPrint, "pretty-print", "p", false,
 		"Format the JSON output indented for human readability")
+	cmd.Flags().StringVarP(&namesFile, "names", "", "",
+		"JSON file of lens and camera name aliases and rules")
+	cmd.Flags().StringSliceVarP(&groupBy, "group-by", "g", dimensionNames(luminosity.DefaultSunburstDimensions),
+		"Dimensions to group photos by, in order ("+strings.Join(dimensionNames(luminosity.AllDimensions), ", ")+")")
+	cmd.Flags().Int64VarP(&minCount, "min-count", "m", 0,
+		"Collect groups with fewer photos than this into an \"Other\" node")
+	cmd.Flags().BoolVarP(&flat, "flat", "", false,
+		"Write flat rows for luminosity.js's SunburstData instead of a tree")
+	cmd.Flags().BoolVarP(&excludeVideos, "exclude-videos", "", false,
+		"Leave videos out of the groups")
+	cmd.Flags().BoolVarP(&includeUnknown, "include-unknown", "", false,
+		"Keep photos with no camera or lens information, under an \"Unknown\" group")
 
 	return cmd
 }
+
+func dimensionNames(dims []luminosity.Dimension) []string {
+	names := make([]string, len(dims))
+	for i, d := range dims {
+		names[i] = string(d)
+	}
+	return names
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/aalpern/luminosity"
 	"github.com/spf13/cobra"
 
 	log "github.com/sirupsen/logrus"
@@ -35,6 +36,11 @@ extracting previews, and managing sidecars.
 	cmd.AddCommand(
 		CmdSunburst(),
 		CmdStats(),
+		CmdBodies(),
+		CmdGear(),
+		CmdExport(),
+		CmdQuery(),
+		CmdSearch(),
 		CmdSidecars(),
 		CmdExtractPreviews())
 
@@ -44,26 +50,125 @@ extracting previews, and managing sidecars.
 	}
 }
 
+// loadNames loads the lens and camera name mappings from path, if
+// one is given, logging any error.
+func loadNames(path string) (*luminosity.NameNormalizer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	names, err := luminosity.LoadNameNormalizer(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"action": "load_names",
+			"file":   path,
+			"error":  err,
+		}).Error("Error loading name mappings")
+	}
+	return names, err
+}
+
+// write writes data as JSON to path, or to stdout if path is "-".
 func write(path string, data interface{}, prettyPrint bool) {
+	output(path, luminosity.FormatJSON, data, prettyPrint)
+}
+
+// dump writes data as JSON to stdout.
+func dump(data interface{}, prettyPrint bool) {
+	output("-", luminosity.FormatJSON, data, prettyPrint)
+}
+
+// output writes data in the given format to path, or to stdout if
+// path is "-". Errors are fatal.
+func output(path string, format luminosity.OutputFormat, data interface{}, prettyPrint bool) {
 	log.WithFields(log.Fields{
 		"action": "write",
 		"file":   path,
-	}).Debug("Writing JSON")
-	var js []byte
-	if prettyPrint {
-		js, _ = json.MarshalIndent(data, "", "  ")
-	} else {
-		js, _ = json.Marshal(data)
+		"format": format,
+	}).Debug("Writing output")
+	if err := writeOutput(path, format, data, prettyPrint); err != nil {
+		log.WithFields(log.Fields{
+			"action": "write",
+			"file":   path,
+			"format": format,
+			"error":  err,
+		}).Fatal("Error writing output")
 	}
-	ioutil.WriteFile(path, js, 0644)
 }
 
-func dump(data interface{}, prettyPrint bool) {
-	var js []byte
-	if prettyPrint {
-		js, _ = json.MarshalIndent(data, "", "  ")
-	} else {
-		js, _ = json.Marshal(data)
+func writeOutput(path string, format luminosity.OutputFormat, data interface{}, prettyPrint bool) error {
+	w, err := luminosity.NewOutputWriter(format, prettyPrint)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return w.Write(os.Stdout, data)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := w.Write(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// outputSplit writes each distribution of stats to its own file in
+// dir, named after the distribution. Errors are fatal.
+func outputSplit(dir string, format luminosity.OutputFormat, stats *luminosity.Stats, prettyPrint bool) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.WithFields(log.Fields{
+			"action": "write",
+			"dir":    dir,
+			"error":  err,
+		}).Fatal("Error creating output directory")
+	}
+	for _, d := range stats.NamedDistributions() {
+		path := filepath.Join(dir, d.Name+format.Extension())
+		output(path, format, []luminosity.NamedDistribution{d}, prettyPrint)
+	}
+}
+
+// writeStats writes the stats of a catalog in the given format to
+// path. If split is set, path is a directory, and each distribution
+// is written to its own file in it.
+func writeStats(path string, format luminosity.OutputFormat, c *luminosity.Catalog, split, prettyPrint bool) {
+	if split {
+		stats, _ := c.GetStats()
+		outputSplit(path, format, stats, prettyPrint)
+		return
+	}
+	output(path, format, c, prettyPrint)
+}
+
+// statsOutfile returns the default path of the stats output for a
+// catalog name and output format.
+func statsOutfile(name string, format luminosity.OutputFormat, split bool) string {
+	if split {
+		return name
+	}
+	return name + format.Extension()
+}
+
+// parseFormat parses the --format flag, logging any error.
+func parseFormat(name string) (luminosity.OutputFormat, error) {
+	format, err := luminosity.ParseOutputFormat(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"action": "parse_flags",
+			"format": name,
+			"error":  err,
+		}).Error("Invalid output format")
+	}
+	return format, err
+}
+
+// formatNames returns the names of the output formats, for flag help.
+func formatNames() string {
+	names := make([]string, len(luminosity.OutputFormats))
+	for i, f := range luminosity.OutputFormats {
+		names[i] = string(f)
 	}
-	fmt.Println(string(js))
+	return strings.Join(names, ", ")
 }
@@ -3,6 +3,8 @@ package luminosity
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 
 	"gopkg.in/guregu/null.v3"
 )
@@ -35,6 +37,26 @@ func (c CollectionType) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// UnmarshalJSON parses the collection type names written by
+// MarshalJSON.
+func (c *CollectionType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	switch name {
+	case "standard":
+		*c = CollectionTypeStandard
+	case "smart":
+		*c = CollectionTypeSmart
+	case "group":
+		*c = CollectionTypeGroup
+	default:
+		return fmt.Errorf("Unknown collection type %q", name)
+	}
+	return nil
+}
+
 type Collection struct {
 	Id       string         `json:"id"`
 	Name     null.String    `json:"name"`
@@ -44,6 +66,19 @@ type Collection struct {
 	Children []*Collection  `json:"children,omitempty"`
 }
 
+// UnmarshalJSON decodes a collection and its children, restoring
+// the back-links from each child to its parent.
+func (c *Collection) UnmarshalJSON(data []byte) error {
+	type collection Collection
+	if err := json.Unmarshal(data, (*collection)(c)); err != nil {
+		return err
+	}
+	for _, child := range c.Children {
+		child.Parent = c
+	}
+	return nil
+}
+
 func (c *Collection) scan(row *sql.Rows) error {
 	var collectionType string
 	if err := row.Scan(&c.Id, &c.Name, &c.ParentId, &collectionType); err != nil {
@@ -0,0 +1,257 @@
+package luminosity
+
+import (
+	"sort"
+)
+
+// EntryDelta compares the count of one distribution entry between a
+// baseline and a current set of photos. Shares are percentages of the
+// total of each distribution, and ShareDelta is the change in share
+// in percentage points.
+type EntryDelta struct {
+	Label         string  `json:"label"`
+	Baseline      int64   `json:"baseline"`
+	Current       int64   `json:"current"`
+	BaselineShare float64 `json:"baseline_share"`
+	CurrentShare  float64 `json:"current_share"`
+	ShareDelta    float64 `json:"share_delta"`
+}
+
+// DistributionComparison compares a distribution between a baseline
+// and a current set of photos.
+type DistributionComparison struct {
+	Name string `json:"name"`
+
+	// Added lists the labels which only occur in the current
+	// distribution, such as newly used lenses, and Dropped those which
+	// only occur in the baseline.
+	Added   []string `json:"added"`
+	Dropped []string `json:"dropped"`
+
+	// Entries holds the delta of every label occurring in either
+	// distribution. Binned distributions are in bin order, others in
+	// descending order of count.
+	Entries []*EntryDelta `json:"entries"`
+}
+
+// CompareDistributions compares two distribution lists by label. If
+// ordered is true, the entries keep the order of the lists, as for
+// binned distributions; otherwise they are sorted by the larger of
+// their two counts.
+func CompareDistributions(name string, baseline, current DistributionList, ordered bool) *DistributionComparison {
+	cmp := &DistributionComparison{
+		Name:    name,
+		Added:   []string{},
+		Dropped: []string{},
+		Entries: []*EntryDelta{},
+	}
+	deltas := map[string]*EntryDelta{}
+	delta := func(label string) *EntryDelta {
+		d, ok := deltas[label]
+		if !ok {
+			d = &EntryDelta{Label: label}
+			deltas[label] = d
+			cmp.Entries = append(cmp.Entries, d)
+		}
+		return d
+	}
+	for _, e := range baseline {
+		delta(e.Label).Baseline += e.Count
+	}
+	for _, e := range current {
+		delta(e.Label).Current += e.Count
+	}
+
+	baselineTotal, currentTotal := baseline.Total(), current.Total()
+	entries := cmp.Entries[:0]
+	for _, d := range cmp.Entries {
+		if d.Baseline == 0 && d.Current == 0 {
+			continue
+		}
+		if baselineTotal > 0 {
+			d.BaselineShare = 100 * float64(d.Baseline) / float64(baselineTotal)
+		}
+		if currentTotal > 0 {
+			d.CurrentShare = 100 * float64(d.Current) / float64(currentTotal)
+		}
+		d.ShareDelta = d.CurrentShare - d.BaselineShare
+		switch {
+		case d.Baseline == 0:
+			cmp.Added = append(cmp.Added, d.Label)
+		case d.Current == 0:
+			cmp.Dropped = append(cmp.Dropped, d.Label)
+		}
+		entries = append(entries, d)
+	}
+	cmp.Entries = entries
+
+	if !ordered {
+		sort.SliceStable(cmp.Entries, func(i, j int) bool {
+			a, b := cmp.Entries[i], cmp.Entries[j]
+			if ma, mb := maxInt64(a.Baseline, a.Current), maxInt64(b.Baseline, b.Current); ma != mb {
+				return ma > mb
+			}
+			return a.Label < b.Label
+		})
+		sort.Strings(cmp.Added)
+		sort.Strings(cmp.Dropped)
+	}
+	return cmp
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// KeeperRateDelta compares a keeper rate between a baseline and a
+// current set of photos. Delta is the change in rate.
+type KeeperRateDelta struct {
+	Label    string      `json:"label"`
+	Baseline *KeeperRate `json:"baseline"`
+	Current  *KeeperRate `json:"current"`
+	Delta    float64     `json:"delta"`
+}
+
+func compareKeeperRate(label string, baseline, current *KeeperRate) *KeeperRateDelta {
+	if baseline == nil {
+		baseline = &KeeperRate{Label: label}
+	}
+	if current == nil {
+		current = &KeeperRate{Label: label}
+	}
+	return &KeeperRateDelta{
+		Label:    label,
+		Baseline: baseline,
+		Current:  current,
+		Delta:    current.Rate - baseline.Rate,
+	}
+}
+
+// compareKeeperRates compares two lists of keeper rates by label.
+func compareKeeperRates(baseline, current KeeperRateList) []*KeeperRateDelta {
+	b := map[string]*KeeperRate{}
+	c := map[string]*KeeperRate{}
+	var labels []string
+	for _, k := range baseline {
+		b[k.Label] = k
+		labels = append(labels, k.Label)
+	}
+	for _, k := range current {
+		c[k.Label] = k
+		if _, ok := b[k.Label]; !ok {
+			labels = append(labels, k.Label)
+		}
+	}
+	sort.Strings(labels)
+	deltas := []*KeeperRateDelta{}
+	for _, label := range labels {
+		deltas = append(deltas, compareKeeperRate(label, b[label], c[label]))
+	}
+	return deltas
+}
+
+// KeeperRateComparison compares the keeper rates of a baseline and a
+// current set of photos, overall and by camera and lens.
+type KeeperRateComparison struct {
+	Overall  *KeeperRateDelta   `json:"overall"`
+	ByCamera []*KeeperRateDelta `json:"by_camera"`
+	ByLens   []*KeeperRateDelta `json:"by_lens"`
+}
+
+// StatsComparison holds the differences between the stats of a
+// baseline and a current set of photos, such as two time windows or
+// two catalogs.
+type StatsComparison struct {
+	Baseline string `json:"baseline"`
+	Current  string `json:"current"`
+
+	BaselinePhotos int64 `json:"baseline_photos"`
+	CurrentPhotos  int64 `json:"current_photos"`
+
+	// Distributions compares each of the distributions listed in
+	// ComparedDistributions.
+	Distributions []*DistributionComparison `json:"distributions"`
+
+	KeeperRates *KeeperRateComparison `json:"keeper_rates"`
+}
+
+// ComparedDistribution names a distribution of the stats compared by
+// CompareStats, and how to extract it.
+type ComparedDistribution struct {
+	Name string
+
+	// Ordered distributions, such as binned ones, keep their order in
+	// the comparison.
+	Ordered bool
+
+	Get func(s *Stats) DistributionList
+}
+
+// ComparedDistributions lists the distributions compared by
+// CompareStats, in order. Numeric distributions are compared by
+// standard ranges and stops rather than by exact value.
+var ComparedDistributions = []ComparedDistribution{
+	{"camera", false, func(s *Stats) DistributionList { return s.ByCamera }},
+	{"lens", false, func(s *Stats) DistributionList { return s.ByLens }},
+	{"focal_length_range", true, func(s *Stats) DistributionList {
+		return s.ByFocalLength.Bin(StandardFocalLengthBins())
+	}},
+	{"equivalent_focal_length_range", true, func(s *Stats) DistributionList {
+		return s.ByEquivalentFocalLength.Bin(StandardFocalLengthBins())
+	}},
+	{"aperture", true, func(s *Stats) DistributionList {
+		return s.ByAperture.Bin(ApertureBins(ThirdStop))
+	}},
+	{"exposure_time", true, func(s *Stats) DistributionList {
+		return s.ByExposureTime.Bin(ExposureTimeBins(ThirdStop))
+	}},
+	{"iso", true, func(s *Stats) DistributionList { return s.ByISO.Bin(ISOBins()) }},
+	{"rating", true, func(s *Stats) DistributionList { return s.ByRating }},
+	{"pick", true, func(s *Stats) DistributionList { return s.ByPick }},
+	{"file_format", false, func(s *Stats) DistributionList { return s.ByFileFormat }},
+	{"orientation", false, func(s *Stats) DistributionList { return s.ByOrientation }},
+	{"aspect_ratio", false, func(s *Stats) DistributionList { return s.ByAspectRatio }},
+	{"keyword", false, func(s *Stats) DistributionList { return s.ByKeyword }},
+}
+
+// photoCount returns the number of photos the stats were computed
+// over.
+func (s *Stats) photoCount() int64 {
+	if s.KeeperRates != nil && s.KeeperRates.Overall != nil {
+		return s.KeeperRates.Overall.Photos
+	}
+	return s.ByDate.Total()
+}
+
+// CompareStats compares the distributions and keeper rates of two
+// sets of stats, labelled for reporting.
+func CompareStats(baselineLabel string, baseline *Stats, currentLabel string, current *Stats) *StatsComparison {
+	cmp := &StatsComparison{
+		Baseline:       baselineLabel,
+		Current:        currentLabel,
+		BaselinePhotos: baseline.photoCount(),
+		CurrentPhotos:  current.photoCount(),
+		Distributions:  []*DistributionComparison{},
+	}
+	for _, d := range ComparedDistributions {
+		cmp.Distributions = append(cmp.Distributions,
+			CompareDistributions(d.Name, d.Get(baseline), d.Get(current), d.Ordered))
+	}
+
+	b, c := baseline.KeeperRates, current.KeeperRates
+	if b == nil {
+		b = newKeeperRates()
+	}
+	if c == nil {
+		c = newKeeperRates()
+	}
+	cmp.KeeperRates = &KeeperRateComparison{
+		Overall:  compareKeeperRate("all", b.Overall, c.Overall),
+		ByCamera: compareKeeperRates(b.ByCamera, c.ByCamera),
+		ByLens:   compareKeeperRates(b.ByLens, c.ByLens),
+	}
+	return cmp
+}
@@ -0,0 +1,106 @@
+package luminosity
+
+import (
+	"reflect"
+	"testing"
+)
+
+// distributionOf returns a distribution list with one entry per
+// label and count pair.
+func distributionOf(pairs ...interface{}) DistributionList {
+	var l DistributionList
+	for i := 0; i < len(pairs); i += 2 {
+		l = append(l, &DistributionEntry{
+			Id:    int64(i / 2),
+			Label: pairs[i].(string),
+			Count: int64(pairs[i+1].(int)),
+		})
+	}
+	return l
+}
+
+func TestCompareDistributions(t *testing.T) {
+	type entry struct {
+		label             string
+		baseline, current int64
+		shareDelta        float64
+	}
+	tests := []struct {
+		name              string
+		baseline, current DistributionList
+		ordered           bool
+		added, dropped    []string
+		entries           []entry
+	}{
+		{
+			name:     "added and dropped, by count",
+			baseline: distributionOf("35mm", 30, "50mm", 10, "85mm", 10),
+			current:  distributionOf("23mm", 60, "35mm", 20, "50mm", 20),
+			added:    []string{"23mm"},
+			dropped:  []string{"85mm"},
+			entries: []entry{
+				{"23mm", 0, 60, 60},
+				{"35mm", 30, 20, -40},
+				{"50mm", 10, 20, 0},
+				{"85mm", 10, 0, -20},
+			},
+		},
+		{
+			name:     "ordered keeps the order of the lists",
+			baseline: distributionOf("f/1.4", 10, "f/2.8", 30),
+			current:  distributionOf("f/2.8", 10, "f/1.4", 30, "f/8", 60),
+			ordered:  true,
+			added:    []string{"f/8"},
+			dropped:  []string{},
+			entries: []entry{
+				{"f/1.4", 10, 30, 30 - 25},
+				{"f/2.8", 30, 10, 10 - 75},
+				{"f/8", 0, 60, 60},
+			},
+		},
+		{
+			name:     "labels repeated and empty entries",
+			baseline: distributionOf("X-T2", 5, "X-T2", 5, "Empty", 0),
+			current:  distributionOf("X-T2", 10, "Empty", 0),
+			added:    []string{},
+			dropped:  []string{},
+			entries: []entry{
+				{"X-T2", 10, 10, 0},
+			},
+		},
+		{
+			name:     "empty baseline",
+			baseline: DistributionList{},
+			current:  distributionOf("ISO 100", 4),
+			added:    []string{"ISO 100"},
+			dropped:  []string{},
+			entries: []entry{
+				{"ISO 100", 0, 4, 100},
+			},
+		},
+		{
+			name:     "both empty",
+			baseline: nil,
+			current:  nil,
+			added:    []string{},
+			dropped:  []string{},
+			entries:  []entry{},
+		},
+	}
+	for _, test := range tests {
+		cmp := CompareDistributions(test.name, test.baseline, test.current, test.ordered)
+		if !reflect.DeepEqual(cmp.Added, test.added) {
+			t.Errorf("%s: added = %v, want %v", test.name, cmp.Added, test.added)
+		}
+		if !reflect.DeepEqual(cmp.Dropped, test.dropped) {
+			t.Errorf("%s: dropped = %v, want %v", test.name, cmp.Dropped, test.dropped)
+		}
+		got := []entry{}
+		for _, e := range cmp.Entries {
+			got = append(got, entry{e.Label, e.Baseline, e.Current, e.ShareDelta})
+		}
+		if !reflect.DeepEqual(got, test.entries) {
+			t.Errorf("%s: entries = %v, want %v", test.name, got, test.entries)
+		}
+	}
+}
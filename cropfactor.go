@@ -0,0 +1,305 @@
+package luminosity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// CropFactorTable maps camera model names, as recorded in the EXIF
+// metadata, to the crop factor of the camera's sensor - the ratio of
+// the diagonal of a 35mm full frame to the diagonal of the sensor.
+type CropFactorTable map[string]float64
+
+// cropFactorRule assigns a crop factor to every camera model starting
+// with a prefix, for product lines which share a sensor size.
+type cropFactorRule struct {
+	prefix string
+	factor float64
+}
+
+// DefaultCropFactors lists the crop factors of camera bodies whose
+// model names do not follow one of the built-in naming rules. It is
+// indexed on first use, and must not be modified afterwards.
+var DefaultCropFactors = CropFactorTable{
+	// Nikon model numbers don't encode the sensor size
+	"NIKON D3":    1.0,
+	"NIKON D3S":   1.0,
+	"NIKON D3X":   1.0,
+	"NIKON D4":    1.0,
+	"NIKON D4S":   1.0,
+	"NIKON D5":    1.0,
+	"NIKON D6":    1.0,
+	"NIKON D600":  1.0,
+	"NIKON D610":  1.0,
+	"NIKON D700":  1.0,
+	"NIKON D750":  1.0,
+	"NIKON D780":  1.0,
+	"NIKON D800":  1.0,
+	"NIKON D800E": 1.0,
+	"NIKON D810":  1.0,
+	"NIKON D850":  1.0,
+	"NIKON Df":    1.0,
+	"NIKON Z 5":   1.0,
+	"NIKON Z 6":   1.0,
+	"NIKON Z 6_2": 1.0,
+	"NIKON Z 7":   1.0,
+	"NIKON Z 7_2": 1.0,
+	"NIKON Z 8":   1.0,
+	"NIKON Z 9":   1.0,
+	"NIKON Z f":   1.0,
+	"NIKON D90":   1.5,
+	"NIKON D200":  1.5,
+	"NIKON D300":  1.5,
+	"NIKON D300S": 1.5,
+	"NIKON D500":  1.5,
+	"NIKON D3100": 1.5,
+	"NIKON D3200": 1.5,
+	"NIKON D3300": 1.5,
+	"NIKON D3400": 1.5,
+	"NIKON D3500": 1.5,
+	"NIKON D5100": 1.5,
+	"NIKON D5200": 1.5,
+	"NIKON D5300": 1.5,
+	"NIKON D5500": 1.5,
+	"NIKON D5600": 1.5,
+	"NIKON D7000": 1.5,
+	"NIKON D7100": 1.5,
+	"NIKON D7200": 1.5,
+	"NIKON D7500": 1.5,
+	"NIKON Z 30":  1.5,
+	"NIKON Z 50":  1.5,
+	"NIKON Z fc":  1.5,
+	// Canon's R model numbers don't encode the sensor size either;
+	// R bodies missing from this list are reported as unknown
+	"Canon EOS R":     1.0,
+	"Canon EOS RP":    1.0,
+	"Canon EOS Ra":    1.0,
+	"Canon EOS R1":    1.0,
+	"Canon EOS R3":    1.0,
+	"Canon EOS R5":    1.0,
+	"Canon EOS R5 C":  1.0,
+	"Canon EOS R5m2":  1.0,
+	"Canon EOS R6":    1.0,
+	"Canon EOS R6m2":  1.0,
+	"Canon EOS R8":    1.0,
+	"Canon EOS R7":    1.6,
+	"Canon EOS R10":   1.6,
+	"Canon EOS R50":   1.6,
+	"Canon EOS R100":  1.6,
+	"Canon EOS R50 V": 1.6,
+	// The K-1 shares the "PENTAX K-1" prefix with the APS-C K-10D,
+	// K-100D and K-110D
+	"PENTAX K-1":         1.0,
+	"PENTAX K-1 Mark II": 1.0,
+	// The M8 reports its model without the "LEICA" prefix
+	"M8 Digital Camera": 1.33,
+}
+
+// cropFactorRules are checked in order, after the table lookup
+// fails. More specific prefixes must come before the more general
+// ones they overlap with.
+var cropFactorRules = []cropFactorRule{
+	// Canon
+	{"canon eos-1d x", 1.0},
+	{"canon eos-1ds", 1.0},
+	{"canon eos 5d", 1.0},
+	{"canon eos 6d", 1.0},
+	{"canon eos-1d c", 1.0},
+	{"canon eos-1d", 1.3},
+	{"canon eos m", 1.6},
+	{"canon eos rebel", 1.6},
+	{"canon eos kiss", 1.6},
+	{"canon eos d30", 1.6},
+	{"canon eos d60", 1.6},
+	// Every other numbered EOS body - the xD, xxD, xxxD and xxxxD
+	// lines - is APS-C. R bodies are listed in DefaultCropFactors.
+	{"canon eos 1", 1.6},
+	{"canon eos 2", 1.6},
+	{"canon eos 3", 1.6},
+	{"canon eos 4", 1.6},
+	{"canon eos 5", 1.6},
+	{"canon eos 6", 1.6},
+	{"canon eos 7", 1.6},
+	{"canon eos 8", 1.6},
+	{"canon eos 9", 1.6},
+	// Fujifilm
+	{"gfx", 0.79},
+	{"x-", 1.5},
+	{"x100", 1.5},
+	{"x70", 1.5},
+	{"xf10", 1.5},
+	// Sony
+	{"ilce-1", 1.0},
+	{"ilce-7", 1.0},
+	{"ilce-9", 1.0},
+	{"ilce-", 1.5},
+	{"nex-", 1.5},
+	{"dsc-rx100", 2.7},
+	{"dsc-rx10", 2.7},
+	{"dsc-rx1", 1.0},
+	{"ilca-99", 1.0},
+	{"slt-a99", 1.0},
+	{"ilca-", 1.5},
+	{"slt-", 1.5},
+	// Micro Four Thirds
+	{"e-m", 2.0},
+	{"e-p", 2.0},
+	{"om-", 2.0},
+	{"dmc-g", 2.0},
+	{"dc-g", 2.0},
+	// Panasonic full frame
+	{"dc-s", 1.0},
+	// Leica
+	{"leica m8", 1.33},
+	{"leica m", 1.0},
+	{"leica q", 1.0},
+	{"leica sl", 1.0},
+	{"leica cl", 1.5},
+	{"leica tl", 1.5},
+	// Pentax
+	{"pentax k", 1.5},
+}
+
+func cropFactorKey(model string) string {
+	return strings.ToLower(strings.Join(strings.Fields(model), " "))
+}
+
+// Normalize returns a copy of the table keyed by normalized model
+// names - lowercase, with runs of whitespace collapsed - so that
+// Lookup matches them case-insensitively in constant time.
+func (t CropFactorTable) Normalize() CropFactorTable {
+	n := make(CropFactorTable, len(t))
+	for name, factor := range t {
+		n[cropFactorKey(name)] = factor
+	}
+	return n
+}
+
+var (
+	defaultCropFactorsOnce sync.Once
+	defaultCropFactorKeys  CropFactorTable
+)
+
+// LoadCropFactors reads a JSON file mapping camera model names to
+// crop factors, e.g. {"X-T2": 1.5, "Pixel 3": 7.6}. The table is
+// returned normalized.
+func LoadCropFactors(path string) (CropFactorTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := CropFactorTable{}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("Error parsing crop factors %s: %s", path, err)
+	}
+	return table.Normalize(), nil
+}
+
+// Lookup returns the crop factor for a camera model. Entries in the
+// table are checked first, by exact model name or by normalized name
+// (see Normalize), then DefaultCropFactors, matching model names
+// case-insensitively, and finally the built-in naming rules for each
+// manufacturer's product lines. Unknown models return false.
+func (t CropFactorTable) Lookup(model string) (float64, bool) {
+	key := cropFactorKey(model)
+	if key == "" {
+		return 0, false
+	}
+	if factor, ok := t[model]; ok {
+		return factor, true
+	}
+	if factor, ok := t[key]; ok {
+		return factor, true
+	}
+	defaultCropFactorsOnce.Do(func() {
+		defaultCropFactorKeys = DefaultCropFactors.Normalize()
+	})
+	if factor, ok := defaultCropFactorKeys[key]; ok {
+		return factor, true
+	}
+	for _, rule := range cropFactorRules {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule.factor, true
+		}
+	}
+	return 0, false
+}
+
+// EquivalentFocalLength returns the 35mm-equivalent focal length, in
+// mm, of a focal length shot with a camera model, or an invalid value
+// if the crop factor of the camera is unknown.
+func (t CropFactorTable) EquivalentFocalLength(model string, focalLength float64) null.Float {
+	if factor, ok := t.Lookup(model); ok {
+		return null.FloatFrom(focalLength * factor)
+	}
+	return null.Float{}
+}
+
+// cropFactor looks up the crop factor of a camera model in the
+// catalog's crop factor table, by its name as recorded in the EXIF
+// metadata and then by its canonical name.
+func (c *Catalog) cropFactor(model string) (float64, bool) {
+	if factor, ok := c.StatsOptions.CropFactors.Lookup(model); ok {
+		return factor, true
+	}
+	return c.StatsOptions.CropFactors.Lookup(c.StatsOptions.Names.Camera(model))
+}
+
+// GetEquivalentFocalLengthDistribution returns a distribution list
+// indicating the number of photos shot at each 35mm-equivalent focal
+// length, rounded to the nearest mm, using the crop factor table in
+// the catalog's StatsOptions. Photos shot with cameras whose crop
+// factor is unknown are not included; they are returned as a second
+// distribution list, counting the photos shot with each unknown
+// camera.
+func (c *Catalog) GetEquivalentFocalLengthDistribution() (DistributionList, DistributionList, error) {
+	const query = `
+SELECT    Camera.value,
+          exif.focalLength,
+          count(*)
+FROM      AgHarvestedExifMetadata   exif
+JOIN      AgInternedExifCameraModel Camera ON Camera.id_local = exif.cameraModelRef
+WHERE     exif.focalLength is not null
+AND       %s
+GROUP BY  Camera.value, exif.focalLength
+`
+	rows, err := c.db.query("get_equivalent_focal_length_distribution",
+		fmt.Sprintf(query, c.imageCondition("exif.image")))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	equivalent := DistributionMap{}
+	unknown := DistributionMap{}
+	for rows.Next() {
+		var camera null.String
+		var focalLength float64
+		var count int64
+		if err := rows.Scan(&camera, &focalLength, &count); err != nil {
+			return nil, nil, err
+		}
+		if factor, ok := c.cropFactor(camera.String); ok {
+			mm := math.Round(focalLength * factor)
+			equivalent.add(int64(mm), fmt.Sprintf("%.0f", mm), count)
+		} else {
+			unknown.add(0, c.StatsOptions.Names.Camera(camera.String), count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	e := equivalent.ToList()
+	sort.Sort(byId(e))
+	u := unknown.ToList()
+	sort.Sort(u)
+	return e, u, nil
+}
@@ -0,0 +1,51 @@
+package luminosity
+
+import "testing"
+
+func TestCropFactorLookup(t *testing.T) {
+	table := CropFactorTable{"Pixel 3": 7.6}.Normalize()
+	tests := []struct {
+		model  string
+		want   float64
+		wantOk bool
+	}{
+		// Supplied table, matched case-insensitively
+		{"Pixel 3", 7.6, true},
+		{"PIXEL  3", 7.6, true},
+
+		// Canon
+		{"Canon EOS 5D Mark IV", 1.0, true},
+		{"Canon EOS 6D", 1.0, true},
+		{"Canon EOS-1D X Mark II", 1.0, true},
+		{"Canon EOS-1Ds Mark III", 1.0, true},
+		{"Canon EOS-1D Mark IV", 1.3, true},
+		{"Canon EOS 7D Mark II", 1.6, true},
+		{"Canon EOS 80D", 1.6, true},
+		{"Canon EOS 450D", 1.6, true},
+		{"Canon EOS 2000D", 1.6, true},
+		{"Canon EOS Rebel T7i", 1.6, true},
+		{"Canon EOS Kiss X9i", 1.6, true},
+		{"Canon EOS M50", 1.6, true},
+		{"Canon EOS R5", 1.0, true},
+		{"Canon EOS R7", 1.6, true},
+		{"Canon EOS R6m3", 0, false},
+
+		// Leica
+		{"LEICA M10", 1.0, true},
+		{"LEICA M8.2", 1.33, true},
+		{"M8 Digital Camera", 1.33, true},
+
+		// Pentax
+		{"PENTAX K-1 Mark II", 1.0, true},
+		{"PENTAX K-10D", 1.5, true},
+
+		{"Mystery Cam", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := table.Lookup(test.model)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("Lookup(%q) = %g, %t, want %g, %t", test.model, got, ok, test.want, test.wantOk)
+		}
+	}
+}
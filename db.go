@@ -2,6 +2,7 @@ package luminosity
 
 import (
 	"database/sql"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
@@ -23,6 +24,25 @@ func OpenDB(path string) (*DB, error) {
 	return &DB{db}, nil
 }
 
+// OpenDBReadOnly opens a database file which will not be written to,
+// on a single connection so that temporary tables and views persist
+// between queries.
+func OpenDBReadOnly(path string) (*DB, error) {
+	uri := "file:" + kURIEscaper.Replace(path) + "?mode=ro"
+	db, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db}, nil
+}
+
+var kURIEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
 func (db *DB) query(label, sql string) (*sql.Rows, error) {
 	fields := log.Fields{
 		"action": "query",
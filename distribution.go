@@ -3,8 +3,10 @@ package luminosity
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
+	"strings"
 
 	"gopkg.in/guregu/null.v3"
 )
@@ -21,6 +23,16 @@ type DistributionEntry struct {
 	Id    int64  `json:"id"`
 	Label string `json:"label"`
 	Count int64  `json:"count"`
+
+	// Derived fields, only populated by WithPercentages()
+	Percent           float64 `json:"percent,omitempty"`
+	Cumulative        int64   `json:"cumulative,omitempty"`
+	CumulativePercent float64 `json:"cumulative_percent,omitempty"`
+
+	// ByCatalog breaks the count down by the name of the catalog it
+	// came from, when merging stats with
+	// StatsOptions.AttributeCatalogs set.
+	ByCatalog map[string]int64 `json:"by_catalog,omitempty"`
 }
 
 type DistributionList []*DistributionEntry
@@ -40,20 +52,54 @@ func (m DistributionMap) ToList() (d DistributionList) {
 	return d
 }
 
+// add increments the count of the entry with the given label,
+// creating it if it is not yet present in the map.
+func (m DistributionMap) add(id int64, label string, count int64) {
+	if e, ok := m[label]; ok {
+		e.Count += count
+	} else {
+		m[label] = &DistributionEntry{
+			Id:    id,
+			Label: label,
+			Count: count,
+		}
+	}
+}
+
 func copyDistributionEntry(d *DistributionEntry) *DistributionEntry {
 	return &DistributionEntry{
-		Id:    d.Id,
-		Count: d.Count,
-		Label: d.Label,
+		Id:                d.Id,
+		Count:             d.Count,
+		Label:             d.Label,
+		Percent:           d.Percent,
+		Cumulative:        d.Cumulative,
+		CumulativePercent: d.CumulativePercent,
+		ByCatalog:         mergeCatalogCounts(nil, d.ByCatalog),
 	}
 }
 
+// mergeCatalogCounts adds the per-catalog counts of other to m,
+// allocating m if needed.
+func mergeCatalogCounts(m, other map[string]int64) map[string]int64 {
+	if len(other) == 0 {
+		return m
+	}
+	if m == nil {
+		m = map[string]int64{}
+	}
+	for name, count := range other {
+		m[name] += count
+	}
+	return m
+}
+
 func MergeDistributions(dists ...DistributionList) DistributionList {
 	merged := DistributionMap{}
 	for _, dist := range dists {
 		for _, entry := range dist {
 			if target, ok := merged[entry.Label]; ok {
 				target.Count = target.Count + entry.Count
+				target.ByCatalog = mergeCatalogCounts(target.ByCatalog, entry.ByCatalog)
 			} else {
 				merged[entry.Label] = copyDistributionEntry(entry)
 			}
@@ -96,6 +142,26 @@ func (c *Catalog) queryDistribution(sql string, fn distributionConvertor) (Distr
 	return convertDistribution(rows, fn)
 }
 
+// collapseDistribution merges entries sharing the same label, which
+// arise when several raw values are rendered to the same standard
+// value, keeping the order in which labels first appear.
+func collapseDistribution(l DistributionList, err error) (DistributionList, error) {
+	if err != nil {
+		return l, err
+	}
+	m := DistributionMap{}
+	var collapsed DistributionList
+	for _, e := range l {
+		if target, ok := m[e.Label]; ok {
+			target.Count += e.Count
+		} else {
+			m[e.Label] = e
+			collapsed = append(collapsed, e)
+		}
+	}
+	return collapsed, nil
+}
+
 func convertDistribution(rows *sql.Rows, fn distributionConvertor) (DistributionList, error) {
 	var entries DistributionList
 	for rows.Next() {
@@ -114,17 +180,28 @@ func convertDistribution(rows *sql.Rows, fn distributionConvertor) (Distribution
 
 // GetPhotoCountsByDate returns a distribution list of the number of
 // photos shot by calendar date for every date present in the
-// catalog. Empty dates are NOT represented in the returned list.
+// catalog, bucketed according to the catalog's StatsOptions time
+// basis. Empty dates are NOT represented in the returned list.
 func (c *Catalog) GetPhotoCountsByDate() (DistributionList, error) {
+	return c.GetPhotoCountsByDateIn(c.StatsOptions.TimeBasis)
+}
+
+// GetPhotoCountsByDateIn returns a distribution list of the number
+// of photos shot by calendar date, with capture times interpreted
+// according to the given time basis.
+func (c *Catalog) GetPhotoCountsByDateIn(basis TimeBasis) (DistributionList, error) {
 	const query = `
 SELECT 0,
-       date(captureTime),
+       %[1]s as day,
        count(*)
 FROM   Adobe_images
-GROUP  BY date(captureTime)
-ORDER  BY date(captureTime)
+WHERE  day is not null
+AND    %[2]s
+GROUP  BY day
+ORDER  BY day
 `
-	return c.queryDistribution(query, defaultDistributionConvertor)
+	return c.queryDistribution(fmt.Sprintf(query, basis.dateExpression("captureTime"), c.imageCondition("id_local")),
+		defaultDistributionConvertor)
 }
 
 type ByDate DistributionList
@@ -137,7 +214,7 @@ func (a ByDate) Less(i, j int) bool {
 
 // GetLensDistribution returns a distribution list indicating the
 // number of photos shot with each different lens present in the EXIF
-// metadata.
+// metadata, with lens names canonicalized by StatsOptions.Names.
 func (c *Catalog) GetLensDistribution() (DistributionList, error) {
 	const query = `
 SELECT    LensRef.id_local      as id,
@@ -148,10 +225,15 @@ FROM      Adobe_images               image
 JOIN      AgharvestedExifMetadata    metadata   ON       image.id_local = metadata.image
 LEFT JOIN AgInternedExifLens         LensRef    ON     LensRef.id_local = metadata.lensRef
 WHERE     id is not null
+AND       %s
 GROUP BY  id
 ORDER BY  count desc
 `
-	return c.queryDistribution(query, defaultDistributionConvertor)
+	l, err := c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image.id_local")), defaultDistributionConvertor)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeDistribution(l, c.StatsOptions.Names.Lens), nil
 }
 
 // GetFocalLengthDistribution returns a distribution list indicating
@@ -165,15 +247,17 @@ SELECT id_local          as id,
 
 FROM   AgHarvestedExifMetadata
 WHERE       focalLength is not null
+AND         %s
 GROUP BY    focalLength
 ORDER BY    count DESC
 `
-	return c.queryDistribution(query, defaultDistributionConvertor)
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image")), defaultDistributionConvertor)
 }
 
 // GetCameraDistribution returns a distribution list indicating the
 // number of photos shot with each different camera present in the
-// EXIF metadata.
+// EXIF metadata, with camera names canonicalized by
+// StatsOptions.Names.
 func (c *Catalog) GetCameraDistribution() (DistributionList, error) {
 	const query = `
 SELECT    Camera.id_local       as id,
@@ -184,50 +268,58 @@ FROM      Adobe_images               image
 JOIN      AgharvestedExifMetadata    metadata   ON      image.id_local = metadata.image
 LEFT JOIN AgInternedExifCameraModel  Camera     ON     Camera.id_local = metadata.cameraModelRef
 WHERE     id is not null
+AND       %s
 GROUP BY  id
 ORDER BY  count desc
 `
-	return c.queryDistribution(query, defaultDistributionConvertor)
+	l, err := c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image.id_local")), defaultDistributionConvertor)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeDistribution(l, c.StatsOptions.Names.Camera), nil
 }
 
 // GetApertureDistribution returns a distribution list indicating the
 // number of photos shot with each aperture setting present in the
-// EXIF metadata.
+// EXIF metadata, snapped to the nearest standard third stop.
 func (c *Catalog) GetApertureDistribution() (DistributionList, error) {
 	const query = `
 SELECT   aperture,
          count(aperture)
 FROM     AgHarvestedExifMetadata
 WHERE    aperture is not null
+AND      %s
 GROUP BY aperture
 ORDER BY aperture
 `
-	return c.queryDistribution(query, func(row *sql.Rows) (*DistributionEntry, error) {
+	return collapseDistribution(c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image")), func(row *sql.Rows) (*DistributionEntry, error) {
 		var aperture float64
 		var count int64
 		if err := row.Scan(&aperture, &count); err != nil {
 			return nil, err
 		}
 		return &DistributionEntry{
-			Label: fmt.Sprintf("%.1f", ApertureToFNumber(aperture)),
+			Label: ApertureToFNumberString(aperture),
 			Count: count,
 		}, nil
-	})
+	}))
 }
 
 // GetExposureTimeDistribution returns a distribution list indicating
 // the number of photos shot with each different exposure time
-// (shutter speed) setting present in the EXIF metadata.
+// (shutter speed) setting present in the EXIF metadata, snapped to
+// the nearest standard third stop.
 func (c *Catalog) GetExposureTimeDistribution() (DistributionList, error) {
 	const query = `
 SELECT   shutterSpeed,
          count(shutterSpeed)
 FROM     AgHarvestedExifMetadata
 WHERE    shutterSpeed is not null
+AND      %s
 GROUP BY shutterSpeed
 ORDER BY shutterSpeed
 `
-	return c.queryDistribution(query, func(row *sql.Rows) (*DistributionEntry, error) {
+	return collapseDistribution(c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image")), func(row *sql.Rows) (*DistributionEntry, error) {
 		var shutter float64
 		var count int64
 		if err := row.Scan(&shutter, &count); err != nil {
@@ -237,9 +329,197 @@ ORDER BY shutterSpeed
 			Label: ShutterSpeedToExposureTime(shutter),
 			Count: count,
 		}, nil
+	}))
+}
+
+// GetISODistribution returns a distribution list indicating the
+// number of photos shot at each ISO speed present in the EXIF
+// metadata.
+func (c *Catalog) GetISODistribution() (DistributionList, error) {
+	const query = `
+SELECT   isoSpeedRating,
+         count(isoSpeedRating)
+FROM     AgHarvestedExifMetadata
+WHERE    isoSpeedRating is not null
+AND      %s
+GROUP BY isoSpeedRating
+ORDER BY isoSpeedRating
+`
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image")), func(row *sql.Rows) (*DistributionEntry, error) {
+		var iso float64
+		var count int64
+		if err := row.Scan(&iso, &count); err != nil {
+			return nil, err
+		}
+		return &DistributionEntry{
+			Id:    int64(iso),
+			Label: fmt.Sprintf("%.0f", iso),
+			Count: count,
+		}, nil
+	})
+}
+
+// exposureSettings is one distinct combination of APEX aperture,
+// shutter speed and ISO, with the number of photos shot with it.
+type exposureSettings struct {
+	aperture     null.Float
+	shutterSpeed null.Float
+	iso          null.Float
+	focalLength  null.Float
+	count        int64
+}
+
+// getExposureSettings returns the number of photos shot with each
+// distinct combination of exposure settings and focal length.
+func (c *Catalog) getExposureSettings() ([]exposureSettings, error) {
+	const query = `
+SELECT   aperture,
+         shutterSpeed,
+         isoSpeedRating,
+         focalLength,
+         count(*)
+FROM     AgHarvestedExifMetadata
+WHERE    %s
+GROUP BY aperture, shutterSpeed, isoSpeedRating, focalLength
+`
+	rows, err := c.db.query("get_exposure_settings", fmt.Sprintf(query, c.imageCondition("image")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []exposureSettings
+	for rows.Next() {
+		var e exposureSettings
+		if err := rows.Scan(&e.aperture, &e.shutterSpeed, &e.iso, &e.focalLength, &e.count); err != nil {
+			return nil, err
+		}
+		settings = append(settings, e)
+	}
+	return settings, rows.Err()
+}
+
+// statsExposureSettings returns the exposure settings of the catalog.
+// While GetStats is running they are queried only once and shared
+// between the distributions derived from them.
+func (c *Catalog) statsExposureSettings() ([]exposureSettings, error) {
+	if c.run == nil {
+		return c.getExposureSettings()
+	}
+	if !c.run.exposuresLoaded {
+		settings, err := c.getExposureSettings()
+		if err != nil {
+			return nil, err
+		}
+		c.run.exposures, c.run.exposuresLoaded = settings, true
+	}
+	return c.run.exposures, nil
+}
+
+// exposureDistribution aggregates the exposure settings of the
+// catalog into a distribution list, using the key function to
+// compute the Id and label of each settings combination. Settings for
+// which the key function returns false are skipped.
+func (c *Catalog) exposureDistribution(key func(e exposureSettings) (int64, string, bool)) (DistributionList, error) {
+	settings, err := c.statsExposureSettings()
+	if err != nil {
+		return nil, err
+	}
+	m := DistributionMap{}
+	for _, e := range settings {
+		if id, label, ok := key(e); ok {
+			m.add(id, label, e.count)
+		}
+	}
+	l := m.ToList()
+	sort.Sort(byId(l))
+	return l, nil
+}
+
+// GetExposureValueDistribution returns a distribution list
+// indicating the number of photos shot at each exposure value (EV),
+// computed from the APEX aperture and shutter speed values and
+// rounded to the nearest whole stop.
+func (c *Catalog) GetExposureValueDistribution() (DistributionList, error) {
+	return c.exposureDistribution(func(e exposureSettings) (int64, string, bool) {
+		if !e.aperture.Valid || !e.shutterSpeed.Valid {
+			return 0, "", false
+		}
+		ev := math.Round(ExposureValue(e.aperture.Float64, e.shutterSpeed.Float64))
+		return int64(ev), fmt.Sprintf("%.0f", ev), true
+	})
+}
+
+// GetLightValueDistribution returns a distribution list indicating
+// the number of photos shot at each light value - the exposure value
+// normalized to ISO 100 (EV100), which reflects the brightness of the
+// scene - rounded to the nearest whole stop.
+func (c *Catalog) GetLightValueDistribution() (DistributionList, error) {
+	return c.exposureDistribution(func(e exposureSettings) (int64, string, bool) {
+		if !e.aperture.Valid || !e.shutterSpeed.Valid || !e.iso.Valid || e.iso.Float64 <= 0 {
+			return 0, "", false
+		}
+		lv := math.Round(LightValue(e.aperture.Float64, e.shutterSpeed.Float64, e.iso.Float64))
+		return int64(lv), fmt.Sprintf("%.0f", lv), true
 	})
 }
 
+const (
+	// JointLabelSeparator separates the two values making up the
+	// label of an entry in a joint distribution, e.g. "2.8 @ 1/250".
+	JointLabelSeparator = " @ "
+)
+
+// JointLabel joins two labels into the label of a joint distribution
+// entry.
+func JointLabel(x, y string) string {
+	return x + JointLabelSeparator + y
+}
+
+// SplitJointLabel splits the label of a joint distribution entry into
+// its two component labels.
+func SplitJointLabel(label string) (string, string) {
+	parts := strings.SplitN(label, JointLabelSeparator, 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// GetApertureExposureTimeDistribution returns a joint distribution
+// list indicating the number of photos shot with each combination of
+// aperture and exposure time. Entry labels are joined with
+// JointLabelSeparator, e.g. "2.8 @ 1/250".
+func (c *Catalog) GetApertureExposureTimeDistribution() (DistributionList, error) {
+	l, err := c.exposureDistribution(func(e exposureSettings) (int64, string, bool) {
+		if !e.aperture.Valid || !e.shutterSpeed.Valid {
+			return 0, "", false
+		}
+		return 0, JointLabel(
+			ApertureToFNumberString(e.aperture.Float64),
+			ShutterSpeedToExposureTime(e.shutterSpeed.Float64)), true
+	})
+	sort.Sort(l)
+	return l, err
+}
+
+// GetISOFocalLengthDistribution returns a joint distribution list
+// indicating the number of photos shot with each combination of ISO
+// speed and focal length. Entry labels are joined with
+// JointLabelSeparator, e.g. "400 @ 35".
+func (c *Catalog) GetISOFocalLengthDistribution() (DistributionList, error) {
+	l, err := c.exposureDistribution(func(e exposureSettings) (int64, string, bool) {
+		if !e.iso.Valid || !e.focalLength.Valid {
+			return 0, "", false
+		}
+		return 0, JointLabel(
+			fmt.Sprintf("%.0f", e.iso.Float64),
+			strconv.FormatFloat(e.focalLength.Float64, 'f', -1, 64)), true
+	})
+	sort.Sort(l)
+	return l, err
+}
+
 // GetEditCountDistribution returns a distribution list grouping
 // counts of photos according to the number of edits that have been
 // made to them (e.g. N photos have 1 edit, M photos have 2 edits, NN
@@ -253,18 +533,36 @@ FROM   (
   SELECT   count(*) as edit_count, 
            image  
   FROM     Adobe_libraryImageDevelopHistoryStep
+  WHERE    %s
   GROUP BY image
   ORDER BY edit_count DESC
 )
 WHERE    edit_count > 1
 GROUP BY edit_count
 `
-	return c.queryDistribution(query, defaultDistributionConvertor)
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("image")), defaultDistributionConvertor)
 }
 
 // GetKeywordDistribution returns a distribution list indicating the
 // number of photos tagged with each keyword present in the catalog.
 func (c *Catalog) GetKeywordDistribution() (DistributionList, error) {
+	// Lightroom's precomputed keyword popularity counts every image, so
+	// count the tagged images directly when excluding some
+	const filteredQuery = `
+SELECT     k.id_local as id,
+           k.name     as label,
+           count(*)   as count
+FROM       AgLibraryKeywordImage ki
+INNER JOIN AgLibraryKeyword      k  ON ki.tag = k.id_local
+WHERE      %s
+GROUP BY   k.id_local
+ORDER BY   count desc
+`
+	if c.StatsOptions.filtersImages() {
+		return c.queryDistribution(fmt.Sprintf(filteredQuery, c.imageCondition("ki.image")),
+			defaultDistributionConvertor)
+	}
+
 	const query = `
 SELECT 	    k.id_local    as id, 
 		    k.name        as label,
@@ -277,53 +575,95 @@ ORDER BY 	p.occurrences desc
 	return c.queryDistribution(query, defaultDistributionConvertor)
 }
 
-// GetSunburstStats returns a list of rows of the number of photos
-// shot grouped by multiple criteria, suitable for transforming into a
-// tree structure capable of feeding a sunburst graph
-// representation. The data is not re-organized into a tree here in
-// order to allow one set of data to be repartitioned at runtime in a
-// web UI (see the accompaning luminosity.js Javascript code).
-func (c *Catalog) GetSunburstStats() ([]map[string]string, error) {
+// GetRatingDistribution returns a distribution list indicating the
+// number of photos with each star rating, from 0 (unrated) to 5.
+func (c *Catalog) GetRatingDistribution() (DistributionList, error) {
 	const query = `
-SELECT    count(*)          as count,
-          image.id_local    as id,
-          Camera.Value      as camera,
-          Lens.value        as lens,
-          exif.aperture     as aperture,
-          exif.focalLength  as focal_length,
-          exif.shutterSpeed as exposure
-FROM      Adobe_images              image
-JOIN      AgharvestedExifMetadata   exif      ON  image.id_local  = exif.image
-LEFT JOIN AgInternedExifLens        Lens      ON  Lens.id_Local   = exif.lensRef
-LEFT JOIN AgInternedExifCameraModel Camera    ON  Camera.id_local = exif.cameraModelRef
-WHERE camera is not null and lens is not null
-GROUP BY camera, lens, aperture, focal_length, exposure
-ORDER BY camera, lens, aperture, focal_length, exposure, count
+SELECT   coalesce(cast(rating as integer), 0) as stars,
+         coalesce(cast(rating as integer), 0),
+         count(*)
+FROM     Adobe_images
+WHERE    %s
+GROUP BY stars
+ORDER BY stars
 `
-	if data, err := c.db.queryStringMap("sunburst_stats", query); err != nil {
-		return data, err
-	} else {
-		for _, record := range data {
-			// Need to convert the APEX aperture values to f-numbers
-			// and the exposure time to shutter speed
-			if apertureStr, ok := record["aperture"]; ok && apertureStr != "" {
-				aperture, err := strconv.ParseFloat(apertureStr, 64)
-				if err != nil {
-					return data, err
-				}
-				record["aperture"] = fmt.Sprintf("f/%.1f", ApertureToFNumber(aperture))
-			}
-			if exposureStr, ok := record["exposure"]; ok && exposureStr != "" {
-				exposure, err := strconv.ParseFloat(exposureStr, 64)
-				if err != nil {
-					return data, err
-				}
-				record["exposure"] = ShutterSpeedToExposureTime(exposure)
-			}
-			if focalLength, ok := record["focal_length"]; ok && focalLength != "" {
-				record["focal_length"] = focalLength + "mm"
-			}
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("id_local")), defaultDistributionConvertor)
+}
+
+const (
+	PickRejected  = -1
+	PickUnflagged = 0
+	PickPicked    = 1
+)
+
+// PickLabel returns the name of a Lightroom pick flag value.
+func PickLabel(pick int64) string {
+	switch {
+	case pick > 0:
+		return "picked"
+	case pick < 0:
+		return "rejected"
+	default:
+		return "unflagged"
+	}
+}
+
+// GetPickDistribution returns a distribution list indicating the
+// number of photos which are picked, unflagged and rejected. Entry
+// ids are the Lightroom pick flag values (see PickPicked, etc...).
+func (c *Catalog) GetPickDistribution() (DistributionList, error) {
+	const query = `
+SELECT   cast(pick as integer) as flag,
+         count(*)
+FROM     Adobe_images
+WHERE    %s
+GROUP BY flag
+ORDER BY flag
+`
+	l, err := c.queryDistribution(fmt.Sprintf(query, c.imageCondition("id_local")), func(row *sql.Rows) (*DistributionEntry, error) {
+		var pick, count int64
+		if err := row.Scan(&pick, &count); err != nil {
+			return nil, err
 		}
-		return data, nil
+		return &DistributionEntry{
+			Id:    pick,
+			Label: PickLabel(pick),
+			Count: count,
+		}, nil
+	})
+	return collapseDistribution(l, err)
+}
+
+// GetColorLabelDistribution returns a distribution list indicating
+// the number of photos with each color label, with photos without a
+// label counted as "none".
+func (c *Catalog) GetColorLabelDistribution() (DistributionList, error) {
+	const query = `
+SELECT   0,
+         CASE colorLabels WHEN '' THEN 'none' ELSE colorLabels END as label,
+         count(*)
+FROM     Adobe_images
+WHERE    %s
+GROUP BY label
+ORDER BY label
+`
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("id_local")), defaultDistributionConvertor)
+}
+
+// GetSunburstStats returns a list of rows of the number of photos
+// shot grouped by camera, lens, aperture, focal length and exposure
+// time, suitable for transforming into a tree structure capable of
+// feeding a sunburst graph representation. The data is not
+// re-organized into a tree here in order to allow one set of data to
+// be repartitioned at runtime in a web UI (see the accompaning
+// luminosity.js Javascript code). Photos with no camera or lens
+// information are skipped. See GroupBy for grouping by other
+// dimensions.
+func (c *Catalog) GetSunburstStats() ([]map[string]string, error) {
+	grouping, err := c.GroupBy(DefaultSunburstDimensions...)
+	if err != nil {
+		return nil, err
 	}
+	grouping.DropUnknown(DimensionCamera, DimensionLens)
+	return grouping.ToMaps(), nil
 }
@@ -0,0 +1,489 @@
+package luminosity
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// PhotoExport is a photo record along with its keywords, the
+// collections containing it, and the catalog it comes from, as written
+// by the photo exporters.
+type PhotoExport struct {
+	CatalogName string `json:"catalog"`
+	*PhotoRecord
+	Keywords    []string `json:"keywords"`
+	Collections []string `json:"collections"`
+}
+
+// PhotoExporter writes exported photo records, one at a time.
+type PhotoExporter interface {
+	// BeginCatalog is called before the photos of each catalog are
+	// exported.
+	BeginCatalog(c *Catalog) error
+	WritePhoto(p *PhotoExport) error
+	// Close flushes any buffered output. It does not close the
+	// underlying writer.
+	Close() error
+}
+
+// ExportPhotos streams every photo record of the catalog selected by
+// StatsOptions (see ExcludeVideos and Window) to the exporter, with
+// its keywords, collections and sequence id (see GetSequenceIds).
+// Photo records are not kept in memory.
+func (c *Catalog) ExportPhotos(exporter PhotoExporter) error {
+	sequences, err := c.GetSequenceIds()
+	if err != nil {
+		return err
+	}
+	keywords, err := c.getImageKeywords()
+	if err != nil {
+		return err
+	}
+	collections, err := c.getImageCollections()
+	if err != nil {
+		return err
+	}
+	if err := exporter.BeginCatalog(c); err != nil {
+		return err
+	}
+	name := c.Name()
+	return c.ForEachPhoto(func(p *PhotoRecord) error {
+		p.SequenceId = sequences[p.Id]
+		e := &PhotoExport{
+			CatalogName: name,
+			PhotoRecord: p,
+			Keywords:    keywords[p.Id],
+			Collections: collections[p.Id],
+		}
+		if e.Keywords == nil {
+			e.Keywords = []string{}
+		}
+		if e.Collections == nil {
+			e.Collections = []string{}
+		}
+		return exporter.WritePhoto(e)
+	})
+}
+
+// ----------------------------------------------------------------------
+// Export columns
+// ----------------------------------------------------------------------
+
+// exportColumn is a column of the tabular photo exports. Values are
+// nil for missing data.
+type exportColumn struct {
+	name    string
+	sqlType string
+	value   func(p *PhotoExport) interface{}
+}
+
+func nullString(s null.String) interface{} {
+	if s.Valid {
+		return s.String
+	}
+	return nil
+}
+
+func nullInt(i null.Int) interface{} {
+	if i.Valid {
+		return i.Int64
+	}
+	return nil
+}
+
+func nullFloat(f null.Float) interface{} {
+	if f.Valid {
+		return f.Float64
+	}
+	return nil
+}
+
+func nullBool(b null.Bool) interface{} {
+	if b.Valid {
+		return b.Bool
+	}
+	return nil
+}
+
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func optionalTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// photoColumns lists the columns of the tabular photo exports. The
+// keywords and collections of the photos are joined in single columns
+// of the CSV and TSV exports, and stored in tables of their own in
+// SQLite exports.
+var photoColumns = []exportColumn{
+	{"image_id", "INTEGER", func(p *PhotoExport) interface{} { return int64(p.Id) }},
+	{"id_global", "TEXT", func(p *PhotoExport) interface{} { return p.IdGlobal }},
+	{"full_name", "TEXT", func(p *PhotoExport) interface{} { return p.FullName }},
+	{"base_name", "TEXT", func(p *PhotoExport) interface{} { return p.BaseName }},
+	{"file_format", "TEXT", func(p *PhotoExport) interface{} { return p.FileFormat }},
+	{"file_width", "INTEGER", func(p *PhotoExport) interface{} { return nullInt(p.FileWidth) }},
+	{"file_height", "INTEGER", func(p *PhotoExport) interface{} { return nullInt(p.FileHeight) }},
+	{"orientation", "TEXT", func(p *PhotoExport) interface{} { return optionalString(p.FrameOrientation()) }},
+	{"capture_time", "TEXT", func(p *PhotoExport) interface{} { return optionalTime(p.CaptureTime) }},
+	{"capture_time_local", "TEXT", func(p *PhotoExport) interface{} { return optionalString(p.CaptureTimeLocal) }},
+	{"capture_time_utc", "TEXT", func(p *PhotoExport) interface{} {
+		if p.CaptureTimeUTC.Valid {
+			return optionalTime(p.CaptureTimeUTC.Time)
+		}
+		return nil
+	}},
+	{"sequence_id", "INTEGER", func(p *PhotoExport) interface{} {
+		if p.SequenceId != 0 {
+			return int64(p.SequenceId)
+		}
+		return nil
+	}},
+	{"camera_make", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.CameraMake) }},
+	{"camera", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.Camera) }},
+	{"camera_serial", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.CameraSerial) }},
+	{"lens", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.Lens) }},
+	{"focal_length", "REAL", func(p *PhotoExport) interface{} {
+		if f, err := strconv.ParseFloat(p.FocalLength.String, 64); err == nil {
+			return f
+		}
+		return nil
+	}},
+	{"equivalent_focal_length", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.EquivalentFocalLength) }},
+	{"crop_factor", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.CropFactor) }},
+	{"fnumber", "REAL", func(p *PhotoExport) interface{} {
+		if p.FNumber != "" {
+			return p.FNumberRaw
+		}
+		return nil
+	}},
+	{"exposure_time", "REAL", func(p *PhotoExport) interface{} {
+		if p.ExposureTime != "" {
+			return p.ExposureTimeRaw
+		}
+		return nil
+	}},
+	{"iso", "INTEGER", func(p *PhotoExport) interface{} {
+		if iso, err := strconv.ParseFloat(p.ISO.String, 64); err == nil {
+			return int64(iso)
+		}
+		return nil
+	}},
+	{"flash_fired", "INTEGER", func(p *PhotoExport) interface{} { return nullBool(p.FlashFired) }},
+	{"rating", "INTEGER", func(p *PhotoExport) interface{} {
+		if r, err := strconv.ParseFloat(p.Rating.String, 64); err == nil {
+			return int64(r)
+		}
+		return int64(0)
+	}},
+	{"pick", "INTEGER", func(p *PhotoExport) interface{} { return nullInt(p.Pick) }},
+	{"color_label", "TEXT", func(p *PhotoExport) interface{} { return optionalString(p.ColorLabels) }},
+	{"has_gps", "INTEGER", func(p *PhotoExport) interface{} { return p.HasGPS }},
+	{"latitude", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.Latitude) }},
+	{"longitude", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.Longitude) }},
+	{"caption", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.Caption) }},
+	{"copyright", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.Copyright) }},
+	{"creator", "TEXT", func(p *PhotoExport) interface{} { return nullString(p.Creator) }},
+	{"is_video", "INTEGER", func(p *PhotoExport) interface{} { return p.IsVideo }},
+	{"duration", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.Duration) }},
+	{"frame_rate", "REAL", func(p *PhotoExport) interface{} { return nullFloat(p.FrameRate) }},
+}
+
+// formatExportValue renders a column value for the CSV and TSV
+// exports.
+func formatExportValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(v)
+}
+
+// ExportListSeparator separates the keywords and collections of a
+// photo in the CSV and TSV exports.
+const ExportListSeparator = "; "
+
+// ----------------------------------------------------------------------
+// CSV / TSV
+// ----------------------------------------------------------------------
+
+type csvPhotoExporter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVPhotoExporter returns an exporter writing one row per photo,
+// with cells separated by comma.
+func NewCSVPhotoExporter(w io.Writer, comma rune) PhotoExporter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &csvPhotoExporter{w: cw}
+}
+
+func (e *csvPhotoExporter) BeginCatalog(c *Catalog) error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	header := []string{"catalog"}
+	for _, col := range photoColumns {
+		header = append(header, col.name)
+	}
+	return e.w.Write(append(header, "keywords", "collections"))
+}
+
+func (e *csvPhotoExporter) WritePhoto(p *PhotoExport) error {
+	row := make([]string, 0, len(photoColumns)+3)
+	row = append(row, p.CatalogName)
+	for _, col := range photoColumns {
+		row = append(row, formatExportValue(col.value(p)))
+	}
+	row = append(row,
+		strings.Join(p.Keywords, ExportListSeparator),
+		strings.Join(p.Collections, ExportListSeparator))
+	return e.w.Write(row)
+}
+
+func (e *csvPhotoExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ----------------------------------------------------------------------
+// NDJSON
+// ----------------------------------------------------------------------
+
+type ndjsonPhotoExporter struct {
+	enc *json.Encoder
+}
+
+// NewNDJSONPhotoExporter returns an exporter writing each photo as a
+// JSON object on its own line.
+func NewNDJSONPhotoExporter(w io.Writer) PhotoExporter {
+	return &ndjsonPhotoExporter{json.NewEncoder(w)}
+}
+
+func (e *ndjsonPhotoExporter) BeginCatalog(c *Catalog) error   { return nil }
+func (e *ndjsonPhotoExporter) WritePhoto(p *PhotoExport) error { return e.enc.Encode(p) }
+func (e *ndjsonPhotoExporter) Close() error                    { return nil }
+
+// ----------------------------------------------------------------------
+// SQLite
+// ----------------------------------------------------------------------
+
+// sqliteExportBatch is the number of photos inserted per transaction.
+const sqliteExportBatch = 5000
+
+// sqliteExportSchema creates the tables of the SQLite export, except
+// for the photos table, whose columns are listed in photoColumns.
+const sqliteExportSchema = `
+CREATE TABLE catalogs (
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    path TEXT NOT NULL
+);
+CREATE TABLE keywords (
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE collections (
+    id         INTEGER PRIMARY KEY,
+    catalog_id INTEGER NOT NULL REFERENCES catalogs(id),
+    name       TEXT NOT NULL,
+    UNIQUE (catalog_id, name)
+);
+CREATE TABLE photo_keywords (
+    photo_id   INTEGER NOT NULL REFERENCES photos(id),
+    keyword_id INTEGER NOT NULL REFERENCES keywords(id),
+    PRIMARY KEY (photo_id, keyword_id)
+);
+CREATE TABLE photo_collections (
+    photo_id      INTEGER NOT NULL REFERENCES photos(id),
+    collection_id INTEGER NOT NULL REFERENCES collections(id),
+    PRIMARY KEY (photo_id, collection_id)
+);
+`
+
+type sqlitePhotoExporter struct {
+	db          *sql.DB
+	tx          *sql.Tx
+	pending     int
+	catalogId   int64
+	keywords    map[string]int64
+	collections map[string]int64
+	insertPhoto string
+}
+
+// NewSQLitePhotoExporter returns an exporter writing a normalized,
+// standalone SQLite database at path, with tables of catalogs,
+// photos, keywords and collections. The file must not already exist.
+func NewSQLitePhotoExporter(path string) (PhotoExporter, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"id INTEGER PRIMARY KEY", "catalog_id INTEGER NOT NULL REFERENCES catalogs(id)"}
+	names := []string{"catalog_id"}
+	for _, col := range photoColumns {
+		columns = append(columns, col.name+" "+col.sqlType)
+		names = append(names, col.name)
+	}
+	schema := sqliteExportSchema +
+		"CREATE TABLE photos (\n    " + strings.Join(columns, ",\n    ") + "\n);\n"
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqlitePhotoExporter{
+		db:       db,
+		keywords: map[string]int64{},
+		insertPhoto: fmt.Sprintf("INSERT INTO photos (%s) VALUES (?%s)",
+			strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1)),
+	}, nil
+}
+
+// begin starts a new transaction if none is in progress.
+func (e *sqlitePhotoExporter) begin() error {
+	if e.tx != nil {
+		return nil
+	}
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	e.tx = tx
+	return nil
+}
+
+// commit commits the transaction in progress, if any.
+func (e *sqlitePhotoExporter) commit() error {
+	if e.tx == nil {
+		return nil
+	}
+	err := e.tx.Commit()
+	e.tx = nil
+	e.pending = 0
+	return err
+}
+
+func (e *sqlitePhotoExporter) BeginCatalog(c *Catalog) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	r, err := e.tx.Exec("INSERT INTO catalogs (name, path) VALUES (?, ?)", c.Name(), c.Path())
+	if err != nil {
+		return err
+	}
+	e.catalogId, err = r.LastInsertId()
+	e.collections = map[string]int64{}
+	return err
+}
+
+// nameId returns the id of a keyword or collection, inserting it if
+// it is not yet known.
+func (e *sqlitePhotoExporter) nameId(ids map[string]int64, name, insert string, args ...interface{}) (int64, error) {
+	if id, ok := ids[name]; ok {
+		return id, nil
+	}
+	r, err := e.tx.Exec(insert, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	ids[name] = id
+	return id, nil
+}
+
+func (e *sqlitePhotoExporter) WritePhoto(p *PhotoExport) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	values := []interface{}{e.catalogId}
+	for _, col := range photoColumns {
+		values = append(values, col.value(p))
+	}
+	r, err := e.tx.Exec(e.insertPhoto, values...)
+	if err != nil {
+		return err
+	}
+	photoId, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, keyword := range p.Keywords {
+		id, err := e.nameId(e.keywords, keyword, "INSERT INTO keywords (name) VALUES (?)", keyword)
+		if err != nil {
+			return err
+		}
+		if _, err := e.tx.Exec("INSERT OR IGNORE INTO photo_keywords VALUES (?, ?)", photoId, id); err != nil {
+			return err
+		}
+	}
+	for _, collection := range p.Collections {
+		id, err := e.nameId(e.collections, collection,
+			"INSERT INTO collections (catalog_id, name) VALUES (?, ?)", e.catalogId, collection)
+		if err != nil {
+			return err
+		}
+		if _, err := e.tx.Exec("INSERT OR IGNORE INTO photo_collections VALUES (?, ?)", photoId, id); err != nil {
+			return err
+		}
+	}
+
+	if e.pending++; e.pending >= sqliteExportBatch {
+		return e.commit()
+	}
+	return nil
+}
+
+func (e *sqlitePhotoExporter) Close() error {
+	err := e.commit()
+	if err == nil {
+		_, err = e.db.Exec(`
+CREATE INDEX photos_catalog        ON photos (catalog_id);
+CREATE INDEX photos_capture_time   ON photos (capture_time);
+CREATE INDEX photo_keywords_kw     ON photo_keywords (keyword_id);
+CREATE INDEX photo_collections_col ON photo_collections (collection_id);
+`)
+	}
+	if cerr := e.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package luminosity
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// GearUsage records when a single camera or lens was used, and how
+// much.
+type GearUsage struct {
+	Name      string           `json:"name"`
+	Count     int64            `json:"count"`
+	FirstUsed time.Time        `json:"first_used"`
+	LastUsed  time.Time        `json:"last_used"`
+	ByMonth   DistributionList `json:"by_month"`
+
+	// Working storage for the monthly counts while loading
+	months DistributionMap
+}
+
+func (g *GearUsage) add(t time.Time) {
+	g.Count++
+	if t.IsZero() {
+		return
+	}
+	if g.FirstUsed.IsZero() || t.Before(g.FirstUsed) {
+		g.FirstUsed = t
+	}
+	if t.After(g.LastUsed) {
+		g.LastUsed = t
+	}
+	_, month := TimeBucketMonth.Key(t)
+	g.months.add(0, month, 1)
+}
+
+func (g *GearUsage) merge(other *GearUsage) {
+	g.Count += other.Count
+	if g.FirstUsed.IsZero() || (!other.FirstUsed.IsZero() && other.FirstUsed.Before(g.FirstUsed)) {
+		g.FirstUsed = other.FirstUsed
+	}
+	if other.LastUsed.After(g.LastUsed) {
+		g.LastUsed = other.LastUsed
+	}
+	g.ByMonth = g.ByMonth.Merge(other.ByMonth)
+}
+
+type GearUsageList []*GearUsage
+
+func (l GearUsageList) Len() int           { return len(l) }
+func (l GearUsageList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l GearUsageList) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+// Merge combines two lists of gear usage, merging the usage of
+// equipment with the same name.
+func (l GearUsageList) Merge(other GearUsageList) GearUsageList {
+	m := map[string]*GearUsage{}
+	var merged GearUsageList
+	for _, list := range []GearUsageList{l, other} {
+		for _, g := range list {
+			if target, ok := m[g.Name]; ok {
+				target.merge(g)
+			} else {
+				c := *g
+				c.ByMonth = MergeDistributions(g.ByMonth)
+				m[g.Name] = &c
+				merged = append(merged, &c)
+			}
+		}
+	}
+	sort.Sort(merged)
+	return merged
+}
+
+// UnusedSince returns the equipment in the list which has not been
+// used since the given time, least recently used first.
+func (l GearUsageList) UnusedSince(t time.Time) GearUsageList {
+	var unused GearUsageList
+	for _, g := range l {
+		if g.LastUsed.Before(t) {
+			unused = append(unused, g)
+		}
+	}
+	sort.SliceStable(unused, func(i, j int) bool {
+		return unused[i].LastUsed.Before(unused[j].LastUsed)
+	})
+	return unused
+}
+
+// GearTimeline summarizes the use of every camera and lens over
+// time.
+type GearTimeline struct {
+	Cameras GearUsageList `json:"cameras"`
+	Lenses  GearUsageList `json:"lenses"`
+
+	// LensCamera counts the photos shot with each combination of
+	// lens and camera. Entry labels are joined with
+	// JointLabelSeparator, e.g. "XF16-55mm F2.8 @ X-T2".
+	LensCamera DistributionList `json:"lens_camera"`
+}
+
+// Merge combines the gear usage of another timeline into g.
+func (g *GearTimeline) Merge(other *GearTimeline) {
+	if other == nil {
+		return
+	}
+	g.Cameras = g.Cameras.Merge(other.Cameras)
+	g.Lenses = g.Lenses.Merge(other.Lenses)
+	g.LensCamera = g.LensCamera.Merge(other.LensCamera)
+}
+
+// GetGearTimeline returns the first and last use and the monthly
+// photo counts of every camera and lens in the catalog, along with
+// the number of photos shot with each lens and camera
+// combination. Camera and lens names are canonicalized by
+// StatsOptions.Names, and months are determined according to
+// StatsOptions.TimeBasis.
+func (c *Catalog) GetGearTimeline() (*GearTimeline, error) {
+	const query = `
+SELECT    Camera.value,
+          Lens.value,
+          image.captureTime
+FROM      Adobe_images              image
+JOIN      AgHarvestedExifMetadata   exif   ON  image.id_local = exif.image
+LEFT JOIN AgInternedExifLens        Lens   ON   Lens.id_local = exif.lensRef
+LEFT JOIN AgInternedExifCameraModel Camera ON Camera.id_local = exif.cameraModelRef
+WHERE     (Camera.value is not null or Lens.value is not null)
+AND       %s
+`
+	if c.GearTimeline != nil {
+		return c.GearTimeline, nil
+	}
+
+	rows, err := c.db.query("get_gear_timeline", fmt.Sprintf(query, c.imageCondition("image.id_local")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cameras := map[string]*GearUsage{}
+	lenses := map[string]*GearUsage{}
+	pairs := DistributionMap{}
+	usage := func(m map[string]*GearUsage, name string) *GearUsage {
+		if g, ok := m[name]; ok {
+			return g
+		}
+		g := &GearUsage{Name: name, months: DistributionMap{}}
+		m[name] = g
+		return g
+	}
+
+	for rows.Next() {
+		var camera, lens, captureTime null.String
+		if err := rows.Scan(&camera, &lens, &captureTime); err != nil {
+			return nil, err
+		}
+		var t time.Time
+		if captureTime.Valid {
+			if parsed, _, err := parseCaptureTime(captureTime.String); err == nil {
+				t = c.StatsOptions.TimeBasis.In(parsed)
+			}
+		}
+
+		cameraName := c.StatsOptions.Names.Camera(camera.String)
+		lensName := c.StatsOptions.Names.Lens(lens.String)
+		if camera.Valid {
+			usage(cameras, cameraName).add(t)
+		}
+		if lens.Valid {
+			usage(lenses, lensName).add(t)
+		}
+		if camera.Valid && lens.Valid {
+			pairs.add(0, JointLabel(lensName, cameraName), 1)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	timeline := &GearTimeline{
+		LensCamera: pairs.ToList(),
+	}
+	for _, g := range cameras {
+		g.ByMonth = g.months.ToList()
+		sort.Sort(g.ByMonth)
+		timeline.Cameras = append(timeline.Cameras, g)
+	}
+	for _, g := range lenses {
+		g.ByMonth = g.months.ToList()
+		sort.Sort(g.ByMonth)
+		timeline.Lenses = append(timeline.Lenses, g)
+	}
+	sort.Sort(timeline.Cameras)
+	sort.Sort(timeline.Lenses)
+	sort.Sort(timeline.LensCamera)
+
+	c.GearTimeline = timeline
+	return c.GearTimeline, nil
+}
@@ -0,0 +1,564 @@
+package luminosity
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// Dimension identifies a property of photos by which they can be
+// grouped with GroupBy.
+type Dimension string
+
+const (
+	DimensionCamera      Dimension = "camera"
+	DimensionLens        Dimension = "lens"
+	DimensionAperture    Dimension = "aperture"
+	DimensionFocalLength Dimension = "focal_length"
+	DimensionExposure    Dimension = "exposure"
+	DimensionISO         Dimension = "iso"
+	DimensionYear        Dimension = "year"
+	DimensionMonth       Dimension = "month"
+	DimensionRating      Dimension = "rating"
+	DimensionLabel       Dimension = "label"
+	DimensionKeyword     Dimension = "keyword"
+	DimensionFolder      Dimension = "folder"
+	DimensionCollection  Dimension = "collection"
+	DimensionFormat      Dimension = "format"
+	DimensionOrientation Dimension = "orientation"
+)
+
+// AllDimensions lists every dimension supported by GroupBy.
+var AllDimensions = []Dimension{
+	DimensionCamera,
+	DimensionLens,
+	DimensionAperture,
+	DimensionFocalLength,
+	DimensionExposure,
+	DimensionISO,
+	DimensionYear,
+	DimensionMonth,
+	DimensionRating,
+	DimensionLabel,
+	DimensionKeyword,
+	DimensionFolder,
+	DimensionCollection,
+	DimensionFormat,
+	DimensionOrientation,
+}
+
+const (
+	// GroupUnknown is the value under which photos missing the
+	// metadata for a dimension are grouped.
+	GroupUnknown = "Unknown"
+
+	// GroupNone is the value under which photos with no color label,
+	// keyword or collection are grouped.
+	GroupNone = "None"
+)
+
+// DefaultSunburstDimensions is the grouping order of GetSunburstStats.
+var DefaultSunburstDimensions = []Dimension{
+	DimensionCamera,
+	DimensionLens,
+	DimensionAperture,
+	DimensionFocalLength,
+	DimensionExposure,
+}
+
+// ParseDimension returns the dimension with the given name.
+func ParseDimension(s string) (Dimension, error) {
+	d := Dimension(strings.ToLower(s))
+	if _, ok := dimensionValues[d]; !ok {
+		return "", fmt.Errorf("Unknown dimension %q", s)
+	}
+	return d, nil
+}
+
+// ParseDimensions parses a list of dimension names.
+func ParseDimensions(names []string) ([]Dimension, error) {
+	var dims []Dimension
+	for _, name := range names {
+		d, err := ParseDimension(name)
+		if err != nil {
+			return nil, err
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// groupValue is the value of one dimension for a photo. Values are
+// ordered by their sort key first, so that numeric values such as
+// apertures sort numerically, and then by label.
+type groupValue struct {
+	label string
+	order float64
+}
+
+func labelValue(label string) []groupValue {
+	return []groupValue{{label: label}}
+}
+
+func numericValue(label string, order float64) []groupValue {
+	return []groupValue{{label: label, order: order}}
+}
+
+func listValues(labels []string) []groupValue {
+	if len(labels) == 0 {
+		return labelValue(GroupNone)
+	}
+	values := make([]groupValue, len(labels))
+	for i, label := range labels {
+		values[i] = groupValue{label: label}
+	}
+	return values
+}
+
+// groupContext holds the per-catalog data needed to compute the
+// dimension values of photos which are not part of the PhotoRecord.
+type groupContext struct {
+	catalog     *Catalog
+	keywords    map[int][]string
+	collections map[int][]string
+}
+
+type dimensionFunc func(p *PhotoRecord, x *groupContext) []groupValue
+
+var dimensionValues = map[Dimension]dimensionFunc{
+	DimensionCamera: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return labelValue(p.Camera.String)
+	},
+	DimensionLens: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return labelValue(p.Lens.String)
+	},
+	DimensionAperture: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.FNumber == "" {
+			return labelValue(GroupUnknown)
+		}
+		return numericValue("f/"+p.FNumber, p.FNumberRaw)
+	},
+	DimensionFocalLength: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return numericString(p.FocalLength, "", "mm")
+	},
+	DimensionExposure: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.ExposureTime == "" {
+			return labelValue(GroupUnknown)
+		}
+		return numericValue(p.ExposureTime, p.ExposureTimeRaw)
+	},
+	DimensionISO: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return numericString(p.ISO, "ISO ", "")
+	},
+	DimensionYear: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.CaptureTime.IsZero() {
+			return labelValue(GroupUnknown)
+		}
+		_, label := TimeBucketYear.Key(x.catalog.StatsOptions.TimeBasis.In(p.CaptureTime))
+		return labelValue(label)
+	},
+	DimensionMonth: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.CaptureTime.IsZero() {
+			return labelValue(GroupUnknown)
+		}
+		_, label := TimeBucketMonth.Key(x.catalog.StatsOptions.TimeBasis.In(p.CaptureTime))
+		return labelValue(label)
+	},
+	DimensionRating: func(p *PhotoRecord, x *groupContext) []groupValue {
+		// Lightroom stores no rating for unrated photos
+		rating, _ := strconv.ParseFloat(p.Rating.String, 64)
+		return numericValue(strconv.Itoa(int(rating)), rating)
+	},
+	DimensionLabel: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.ColorLabels == "" {
+			return labelValue(GroupNone)
+		}
+		return labelValue(p.ColorLabels)
+	},
+	DimensionKeyword: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return listValues(x.keywords[p.Id])
+	},
+	DimensionFolder: func(p *PhotoRecord, x *groupContext) []groupValue {
+		// Lightroom paths always use forward slashes
+		return labelValue(path.Dir(p.FullName) + "/")
+	},
+	DimensionCollection: func(p *PhotoRecord, x *groupContext) []groupValue {
+		return listValues(x.collections[p.Id])
+	},
+	DimensionFormat: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if p.FileFormat == "" {
+			return labelValue(GroupUnknown)
+		}
+		return labelValue(p.FileFormat)
+	},
+	DimensionOrientation: func(p *PhotoRecord, x *groupContext) []groupValue {
+		if o := p.FrameOrientation(); o != "" {
+			return labelValue(o)
+		}
+		return labelValue(GroupUnknown)
+	},
+}
+
+// numericString returns the value of a numeric EXIF field formatted
+// as an integer if it has no fractional part, with a prefix and
+// suffix.
+func numericString(s null.String, prefix, suffix string) []groupValue {
+	n, err := strconv.ParseFloat(s.String, 64)
+	if !s.Valid || err != nil {
+		return labelValue(GroupUnknown)
+	}
+	return numericValue(prefix+strconv.FormatFloat(n, 'f', -1, 64)+suffix, n)
+}
+
+// GroupRow is the number of photos sharing one combination of
+// dimension values.
+type GroupRow struct {
+	// Values holds the value of each dimension, in the order of
+	// Grouping.Dimensions.
+	Values []string `json:"values"`
+	Count  int64    `json:"count"`
+
+	// Sort keys of each value
+	order []float64
+}
+
+func (r *GroupRow) key() string {
+	return strings.Join(r.Values, "\x00")
+}
+
+type GroupRowList []*GroupRow
+
+func (l GroupRowList) Len() int      { return len(l) }
+func (l GroupRowList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l GroupRowList) Less(i, j int) bool {
+	a, b := l[i], l[j]
+	for n := range a.Values {
+		if n < len(a.order) && n < len(b.order) && a.order[n] != b.order[n] {
+			return a.order[n] < b.order[n]
+		}
+		if a.Values[n] != b.Values[n] {
+			return a.Values[n] < b.Values[n]
+		}
+	}
+	return false
+}
+
+// Grouping holds the number of photos for every combination of the
+// values of a list of dimensions. Photos with several keywords or
+// in several collections are counted once under each, so the row
+// counts may add up to more than the number of photos.
+type Grouping struct {
+	Dimensions []Dimension  `json:"dimensions"`
+	Rows       GroupRowList `json:"rows"`
+}
+
+// Merge adds the counts of another grouping by the same dimensions
+// into g. This is the way to group the photos of several catalogs,
+// as GroupBy only reads a single catalog.
+func (g *Grouping) Merge(other *Grouping) error {
+	if other == nil {
+		return nil
+	}
+	if len(g.Dimensions) == 0 && len(g.Rows) == 0 {
+		g.Dimensions = other.Dimensions
+	}
+	if !sameDimensions(g.Dimensions, other.Dimensions) {
+		return fmt.Errorf("Cannot merge grouping by %v into grouping by %v",
+			other.Dimensions, g.Dimensions)
+	}
+	rows := map[string]*GroupRow{}
+	for _, r := range g.Rows {
+		rows[r.key()] = r
+	}
+	for _, r := range other.Rows {
+		if target, ok := rows[r.key()]; ok {
+			target.Count += r.Count
+		} else {
+			c := *r
+			rows[r.key()] = &c
+			g.Rows = append(g.Rows, &c)
+		}
+	}
+	sort.Sort(g.Rows)
+	return nil
+}
+
+// DropUnknown removes the rows whose value is GroupUnknown for any of
+// the given dimensions. Dimensions which g is not grouped by are
+// ignored.
+func (g *Grouping) DropUnknown(dims ...Dimension) {
+	var columns []int
+	for i, d := range g.Dimensions {
+		for _, u := range dims {
+			if d == u {
+				columns = append(columns, i)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return
+	}
+	rows := GroupRowList{}
+	for _, r := range g.Rows {
+		keep := true
+		for _, i := range columns {
+			if r.Values[i] == GroupUnknown {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			rows = append(rows, r)
+		}
+	}
+	g.Rows = rows
+}
+
+func sameDimensions(a, b []Dimension) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ToMaps returns the rows of the grouping as maps of dimension name
+// to value, plus the count, the format consumed by the SunburstData
+// class in luminosity.js.
+func (g *Grouping) ToMaps() []map[string]string {
+	maps := make([]map[string]string, 0, len(g.Rows))
+	for _, r := range g.Rows {
+		m := map[string]string{
+			"count": strconv.FormatInt(r.Count, 10),
+		}
+		for i, d := range g.Dimensions {
+			m[string(d)] = r.Values[i]
+		}
+		maps = append(maps, m)
+	}
+	return maps
+}
+
+// GroupBy counts the photos in the catalog for every combination of
+// the values of the given dimensions. It reads the photo records from
+// the catalog database, so cannot be used on a catalog created by
+// NewCatalog or LoadCatalogJSON: to group across several catalogs,
+// group each opened catalog and combine the results with
+// Grouping.Merge.
+func (c *Catalog) GroupBy(dims ...Dimension) (*Grouping, error) {
+	if c.db == nil {
+		return nil, ErrorNoDatabase
+	}
+	fns := make([]dimensionFunc, len(dims))
+	x := &groupContext{catalog: c}
+	for i, d := range dims {
+		fn, ok := dimensionValues[d]
+		if !ok {
+			return nil, fmt.Errorf("Unknown dimension %q", string(d))
+		}
+		fns[i] = fn
+
+		var err error
+		if d == DimensionKeyword && x.keywords == nil {
+			x.keywords, err = c.getImageKeywords()
+		} else if d == DimensionCollection && x.collections == nil {
+			x.collections, err = c.getImageCollections()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	photos, err := c.GetPhotos()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]*GroupRow{}
+	grouping := &Grouping{Dimensions: dims}
+	values := make([][]groupValue, len(dims))
+	for _, p := range photos {
+		for i, fn := range fns {
+			values[i] = fn(p, x)
+		}
+		// Count the photo under every combination of values of
+		// multi-valued dimensions
+		forEachCombination(values, func(combination []groupValue) {
+			row := &GroupRow{
+				Values: make([]string, len(combination)),
+				order:  make([]float64, len(combination)),
+			}
+			for i, v := range combination {
+				row.Values[i] = v.label
+				row.order[i] = v.order
+			}
+			if target, ok := rows[row.key()]; ok {
+				target.Count++
+			} else {
+				row.Count = 1
+				rows[row.key()] = row
+				grouping.Rows = append(grouping.Rows, row)
+			}
+		})
+	}
+	sort.Sort(grouping.Rows)
+	return grouping, nil
+}
+
+func forEachCombination(values [][]groupValue, fn func([]groupValue)) {
+	combination := make([]groupValue, len(values))
+	var walk func(int)
+	walk = func(n int) {
+		if n == len(values) {
+			fn(combination)
+			return
+		}
+		for _, v := range values[n] {
+			combination[n] = v
+			walk(n + 1)
+		}
+	}
+	walk(0)
+}
+
+// getImageKeywords returns the names of the keywords applied to each
+// image, keyed by image id.
+func (c *Catalog) getImageKeywords() (map[int][]string, error) {
+	const query = `
+SELECT   ki.image,
+         k.name
+FROM     AgLibraryKeywordImage ki
+JOIN     AgLibraryKeyword      k  ON k.id_local = ki.tag
+WHERE    k.name is not null
+ORDER BY k.name
+`
+	return c.queryImageNames("get_image_keywords", query)
+}
+
+// getImageCollections returns the names of the collections
+// containing each image, keyed by image id. System collections are
+// ignored.
+func (c *Catalog) getImageCollections() (map[int][]string, error) {
+	const query = `
+SELECT   ci.image,
+         col.name
+FROM     AgLibraryCollectionImage ci
+JOIN     AgLibraryCollection      col ON col.id_local = ci.collection
+WHERE    col.systemOnly = 0
+AND      col.name is not null
+ORDER BY col.name
+`
+	return c.queryImageNames("get_image_collections", query)
+}
+
+func (c *Catalog) queryImageNames(label, query string) (map[int][]string, error) {
+	rows, err := c.db.query(label, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[int][]string{}
+	for rows.Next() {
+		var image int
+		var name string
+		if err := rows.Scan(&image, &name); err != nil {
+			return nil, err
+		}
+		names[image] = append(names[image], name)
+	}
+	return names, rows.Err()
+}
+
+// GroupOther is the name of the node under which GroupNode.Prune
+// collects the groups which are too small to show individually.
+const GroupOther = "Other"
+
+// GroupNode is a node of the hierarchical form of a grouping, in the
+// {name, size, children} structure consumed by d3 sunburst and
+// treemap layouts. Size is the total number of photos under the node,
+// but is only written to JSON for leaf nodes (see MarshalJSON).
+type GroupNode struct {
+	Name     string       `json:"name"`
+	Size     int64        `json:"size"`
+	Children []*GroupNode `json:"children,omitempty"`
+
+	index map[string]*GroupNode
+}
+
+// MarshalJSON writes the node with its size only if it is a leaf. d3
+// adds the value of each node to the sum of its children, so the tree
+// is meant to be summed from the leaves, with
+// d3.hierarchy(root).sum(d => d.size); interior sizes would count
+// every photo once more per level.
+func (n *GroupNode) MarshalJSON() ([]byte, error) {
+	type node struct {
+		Name     string       `json:"name"`
+		Size     int64        `json:"size,omitempty"`
+		Children []*GroupNode `json:"children,omitempty"`
+	}
+	v := node{Name: n.Name, Children: n.Children}
+	if len(n.Children) == 0 {
+		v.Size = n.Size
+	}
+	return json.Marshal(v)
+}
+
+func (n *GroupNode) child(name string) *GroupNode {
+	if n.index == nil {
+		n.index = map[string]*GroupNode{}
+	}
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &GroupNode{Name: name}
+	n.index[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// Tree returns the grouping as a tree with one level per dimension,
+// in the order of g.Dimensions, under a root node with the given
+// name. Children are ordered by dimension value.
+func (g *Grouping) Tree(name string) *GroupNode {
+	root := &GroupNode{Name: name}
+	for _, r := range g.Rows {
+		node := root
+		node.Size += r.Count
+		for _, v := range r.Values {
+			node = node.child(v)
+			node.Size += r.Count
+		}
+	}
+	return root
+}
+
+// Prune removes every descendant of n with fewer than minCount
+// photos. The photos of the removed children of each node are
+// gathered into a single GroupOther leaf, so node sizes still add up.
+func (n *GroupNode) Prune(minCount int64) {
+	var kept []*GroupNode
+	var other int64
+	for _, c := range n.Children {
+		if c.Size < minCount {
+			other += c.Size
+			continue
+		}
+		c.Prune(minCount)
+		kept = append(kept, c)
+	}
+	if other > 0 {
+		kept = append(kept, &GroupNode{Name: GroupOther, Size: other})
+	}
+	n.Children = kept
+	n.index = nil
+}
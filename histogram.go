@@ -0,0 +1,269 @@
+package luminosity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// Bins
+// ----------------------------------------------------------------------
+
+// Bin is a labelled numeric range, including Min and excluding Max,
+// used to group the entries of a distribution list.
+type Bin struct {
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Contains returns true if v falls within the bin.
+func (b Bin) Contains(v float64) bool {
+	return v >= b.Min && v < b.Max
+}
+
+type Bins []Bin
+
+// Find returns the index of the bin containing v, or -1 if v is not
+// within any bin.
+func (bins Bins) Find(v float64) int {
+	for i, b := range bins {
+		if b.Contains(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// BinsFromEdges constructs a list of bins from a sorted list of bin
+// edges, labelling each with the format function. Use math.Inf to
+// create open ended bins at either end.
+func BinsFromEdges(edges []float64, format func(min, max float64) string) Bins {
+	var bins Bins
+	for i := 0; i+1 < len(edges); i++ {
+		bins = append(bins, Bin{
+			Label: format(edges[i], edges[i+1]),
+			Min:   edges[i],
+			Max:   edges[i+1],
+		})
+	}
+	return bins
+}
+
+// NominalBins constructs a list of bins centered on a sorted list of
+// nominal values, such as a sequence of standard camera settings. The
+// boundary between two neighbouring values is their geometric mean,
+// since camera settings progress geometrically. The first and last
+// bins are open ended.
+func NominalBins(values []float64, format func(float64) string) Bins {
+	var bins Bins
+	for i, v := range values {
+		b := Bin{
+			Label: format(v),
+			Min:   0,
+			Max:   math.Inf(1),
+		}
+		if i > 0 {
+			b.Min = math.Sqrt(values[i-1] * v)
+		}
+		if i+1 < len(values) {
+			b.Max = math.Sqrt(v * values[i+1])
+		}
+		bins = append(bins, b)
+	}
+	return bins
+}
+
+// ----------------------------------------------------------------------
+// Standard Photographic Bins
+// ----------------------------------------------------------------------
+
+// StandardFocalLengthEdges divides focal lengths, in mm, into the
+// conventional ranges from ultra-wide to super-telephoto.
+var StandardFocalLengthEdges = []float64{
+	0, 16, 24, 35, 50, 70, 105, 200, 400, math.Inf(1),
+}
+
+// StandardFocalLengthBins groups focal lengths into the conventional
+// ranges from ultra-wide (under 16mm) to super-telephoto (400mm and
+// over).
+func StandardFocalLengthBins() Bins {
+	return BinsFromEdges(StandardFocalLengthEdges, formatFocalLengthRange)
+}
+
+// ApertureBins groups f-numbers to the nearest standard stop for
+// the given stop increment.
+func ApertureBins(inc StopIncrement) Bins {
+	return NominalBins(inc.FNumbers(), FormatFNumber)
+}
+
+// ExposureTimeBins groups exposure times, in seconds, to the nearest
+// standard stop for the given stop increment.
+func ExposureTimeBins(inc StopIncrement) Bins {
+	return NominalBins(inc.ExposureTimes(), FormatExposureTime)
+}
+
+// ISOBins groups ISO speeds to the nearest one-third stop.
+func ISOBins() Bins {
+	return NominalBins(ThirdStopISOs, func(v float64) string {
+		return fmt.Sprintf("%.0f", v)
+	})
+}
+
+func formatFocalLengthRange(min, max float64) string {
+	switch {
+	case min <= 0:
+		return fmt.Sprintf("<%gmm", max)
+	case math.IsInf(max, 1):
+		return fmt.Sprintf("%gmm+", min)
+	default:
+		return fmt.Sprintf("%g-%gmm", min, max)
+	}
+}
+
+// ----------------------------------------------------------------------
+// Distribution List Binning
+// ----------------------------------------------------------------------
+
+const (
+	// OtherLabel is the label of the entry which collects the
+	// entries of a distribution list which do not fall into any bin,
+	// or which are outside the top N.
+	OtherLabel = "Other"
+)
+
+// ParseNumericLabel extracts a numeric value from a distribution
+// entry label, as rendered by the distribution queries - e.g. "23.7",
+// "f/2.8", "1/250", "35mm" or "2s".
+func ParseNumericLabel(label string) (float64, bool) {
+	s := strings.TrimSpace(label)
+	s = strings.TrimPrefix(s, "f/")
+	s = strings.TrimPrefix(s, "ISO ")
+	s = strings.TrimSuffix(s, "mm")
+	s = strings.TrimSuffix(s, "\"")
+	s = strings.TrimSuffix(s, "s")
+	s = strings.TrimSpace(s)
+
+	if parts := strings.SplitN(s, "/", 2); len(parts) == 2 {
+		num, err1 := strconv.ParseFloat(parts[0], 64)
+		den, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil || den == 0 {
+			return 0, false
+		}
+		return num / den, true
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// Total returns the sum of the counts of every entry in the list.
+func (l DistributionList) Total() int64 {
+	var total int64
+	for _, e := range l {
+		total += e.Count
+	}
+	return total
+}
+
+// Bin groups the entries of a distribution list into bins according
+// to the numeric value of their labels. The returned list has one
+// entry per bin, in bin order, with the entry Id set to the index of
+// the bin. Entries whose labels are not numeric or fall outside all
+// bins are collected into a final entry labelled OtherLabel, which is
+// omitted if empty.
+func (l DistributionList) Bin(bins Bins) DistributionList {
+	binned := make(DistributionList, len(bins))
+	for i, b := range bins {
+		binned[i] = &DistributionEntry{
+			Id:    int64(i),
+			Label: b.Label,
+		}
+	}
+	other := &DistributionEntry{
+		Id:    int64(len(bins)),
+		Label: OtherLabel,
+	}
+	for _, e := range l {
+		if v, ok := ParseNumericLabel(e.Label); ok {
+			if i := bins.Find(v); i >= 0 {
+				binned[i].Count += e.Count
+				continue
+			}
+		}
+		other.Count += e.Count
+	}
+	if other.Count > 0 {
+		binned = append(binned, other)
+	}
+	return binned
+}
+
+// TopN returns the n entries of the list with the highest counts,
+// in descending order of count, followed by a single entry labelled
+// OtherLabel summing up all the remaining entries, if there are any.
+func (l DistributionList) TopN(n int) DistributionList {
+	sorted := make(DistributionList, 0, len(l))
+	for _, e := range l {
+		sorted = append(sorted, copyDistributionEntry(e))
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Count > sorted[j].Count
+	})
+	if n < 0 || len(sorted) <= n {
+		return sorted
+	}
+	other := &DistributionEntry{
+		Label: OtherLabel,
+	}
+	for _, e := range sorted[n:] {
+		other.Count += e.Count
+	}
+	return append(sorted[:n], other)
+}
+
+// WithPercentages returns a copy of the list with the Percent,
+// Cumulative and CumulativePercent fields of each entry computed,
+// accumulating in list order.
+func (l DistributionList) WithPercentages() DistributionList {
+	total := l.Total()
+	var cumulative int64
+	result := make(DistributionList, 0, len(l))
+	for _, e := range l {
+		c := copyDistributionEntry(e)
+		cumulative += c.Count
+		c.Cumulative = cumulative
+		if total > 0 {
+			c.Percent = 100 * float64(c.Count) / float64(total)
+			c.CumulativePercent = 100 * float64(cumulative) / float64(total)
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// StandardHistograms returns the numeric distributions of the stats
+// binned into the standard photographic bins, with percentages
+// computed, keyed by distribution name. If topN is greater than zero,
+// the lens, camera and keyword distributions are also included,
+// reduced to their topN entries.
+func (s *Stats) StandardHistograms(topN int) map[string]DistributionList {
+	h := map[string]DistributionList{
+		"by_focal_length":  s.ByFocalLength.Bin(StandardFocalLengthBins()).WithPercentages(),
+		"by_aperture":      s.ByAperture.Bin(ApertureBins(ThirdStop)).WithPercentages(),
+		"by_exposure_time": s.ByExposureTime.Bin(ExposureTimeBins(ThirdStop)).WithPercentages(),
+		"by_iso":           s.ByISO.Bin(ISOBins()).WithPercentages(),
+	}
+	if topN > 0 {
+		h["by_lens"] = s.ByLens.TopN(topN).WithPercentages()
+		h["by_camera"] = s.ByCamera.TopN(topN).WithPercentages()
+		h["by_keyword"] = s.ByKeyword.TopN(topN).WithPercentages()
+	}
+	return h
+}
@@ -1,9 +1,13 @@
 /**
- * SunburstData accepts data output by the `luminosity sunburst`
+ * SunburstData accepts data output by the `luminosity sunburst --flat`
  * command and a list of fields to hierarchically group the data by
- * for presentation as a sunburst chart.
+ * for presentation as a sunburst chart. Without --flat the command
+ * writes the {name, size, children} tree directly, which can be
+ * passed to d3 without this helper. Only leaf nodes carry a size, so
+ * sum the tree with d3.hierarchy(root).sum(d => d.size).
  * 
- * Current available fields to group by are:
+ * The fields available to group by are the dimensions passed to the
+ * command's --group-by flag, by default:
  *   - camera
  *   - lens
  *   - aperture
@@ -0,0 +1,156 @@
+package luminosity
+
+import (
+	"fmt"
+	"sort"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// KeeperRate is the fraction of photos which are keepers - rated with
+// at least one star, or flagged as picked - among a set of
+// photos. Rejected photos are never keepers, whatever their rating.
+type KeeperRate struct {
+	Label   string  `json:"label"`
+	Photos  int64   `json:"photos"`
+	Keepers int64   `json:"keepers"`
+	Rate    float64 `json:"rate"`
+}
+
+func (k *KeeperRate) add(photos, keepers int64) {
+	k.Photos += photos
+	k.Keepers += keepers
+	if k.Photos > 0 {
+		k.Rate = float64(k.Keepers) / float64(k.Photos)
+	}
+}
+
+type KeeperRateList []*KeeperRate
+
+func (l KeeperRateList) Len() int           { return len(l) }
+func (l KeeperRateList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l KeeperRateList) Less(i, j int) bool { return l[i].Label < l[j].Label }
+
+// Merge combines two lists of keeper rates, summing the photos and
+// keepers of entries with the same label.
+func (l KeeperRateList) Merge(other KeeperRateList) KeeperRateList {
+	m := keeperRateMap{}
+	for _, list := range []KeeperRateList{l, other} {
+		for _, k := range list {
+			m.add(k.Label, k.Photos, k.Keepers)
+		}
+	}
+	return m.toList()
+}
+
+type keeperRateMap map[string]*KeeperRate
+
+func (m keeperRateMap) add(label string, photos, keepers int64) {
+	k, ok := m[label]
+	if !ok {
+		k = &KeeperRate{Label: label}
+		m[label] = k
+	}
+	k.add(photos, keepers)
+}
+
+func (m keeperRateMap) toList() KeeperRateList {
+	l := KeeperRateList{}
+	for _, k := range m {
+		l = append(l, k)
+	}
+	sort.Sort(l)
+	return l
+}
+
+// KeeperRates gathers the overall keeper rate of a catalog and its
+// breakdown by camera, lens and year of capture.
+type KeeperRates struct {
+	Overall  *KeeperRate    `json:"overall"`
+	ByCamera KeeperRateList `json:"by_camera"`
+	ByLens   KeeperRateList `json:"by_lens"`
+	ByYear   KeeperRateList `json:"by_year"`
+}
+
+func newKeeperRates() *KeeperRates {
+	return &KeeperRates{
+		Overall:  &KeeperRate{Label: "all"},
+		ByCamera: KeeperRateList{},
+		ByLens:   KeeperRateList{},
+		ByYear:   KeeperRateList{},
+	}
+}
+
+// Merge adds the photo and keeper counts of other into k.
+func (k *KeeperRates) Merge(other *KeeperRates) {
+	if other == nil {
+		return
+	}
+	if other.Overall != nil {
+		k.Overall.add(other.Overall.Photos, other.Overall.Keepers)
+	}
+	k.ByCamera = k.ByCamera.Merge(other.ByCamera)
+	k.ByLens = k.ByLens.Merge(other.ByLens)
+	k.ByYear = k.ByYear.Merge(other.ByYear)
+}
+
+// GetKeeperRates returns the keeper rate of the photos in the
+// catalog, overall and by camera, lens and year. Camera and lens
+// names are canonicalized by StatsOptions.Names, and years are
+// determined according to StatsOptions.TimeBasis. Photos without
+// camera, lens or capture time information are only counted in the
+// overall rate and the breakdowns they have information for.
+func (c *Catalog) GetKeeperRates() (*KeeperRates, error) {
+	const query = `
+SELECT    Camera.value,
+          Lens.value,
+          substr(%[1]s, 1, 4) as year,
+          count(*),
+          sum(CASE WHEN image.pick >= 0
+                    AND (coalesce(image.rating, 0) > 0 OR image.pick > 0)
+                   THEN 1 ELSE 0 END)
+FROM      Adobe_images              image
+LEFT JOIN AgHarvestedExifMetadata   exif   ON  image.id_local = exif.image
+LEFT JOIN AgInternedExifLens        Lens   ON   Lens.id_local = exif.lensRef
+LEFT JOIN AgInternedExifCameraModel Camera ON Camera.id_local = exif.cameraModelRef
+WHERE     %[2]s
+GROUP BY  Camera.value, Lens.value, year
+`
+	rows, err := c.db.query("get_keeper_rates",
+		fmt.Sprintf(query, c.StatsOptions.TimeBasis.dateExpression("image.captureTime"),
+			c.imageCondition("image.id_local")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := newKeeperRates()
+	cameras := keeperRateMap{}
+	lenses := keeperRateMap{}
+	years := keeperRateMap{}
+	for rows.Next() {
+		var camera, lens, year null.String
+		var photos, keepers int64
+		if err := rows.Scan(&camera, &lens, &year, &photos, &keepers); err != nil {
+			return nil, err
+		}
+		rates.Overall.add(photos, keepers)
+		if camera.Valid {
+			cameras.add(c.StatsOptions.Names.Camera(camera.String), photos, keepers)
+		}
+		if lens.Valid {
+			lenses.add(c.StatsOptions.Names.Lens(lens.String), photos, keepers)
+		}
+		if year.Valid {
+			years.add(year.String, photos, keepers)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rates.ByCamera = cameras.toList()
+	rates.ByLens = lenses.toList()
+	rates.ByYear = years.toList()
+	return rates, nil
+}
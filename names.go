@@ -0,0 +1,202 @@
+package luminosity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NameRule maps every name matching a regular expression to a
+// canonical name. The canonical name may refer to submatches of the
+// expression, as in regexp.Expand (e.g. "${1}mm").
+type NameRule struct {
+	Match string `json:"match"`
+	Name  string `json:"name"`
+
+	re *regexp.Regexp
+}
+
+// NameMapping holds the user supplied aliases and rules for one kind
+// of name. Alias keys are matched case-insensitively, after the
+// built-in formatting rules have been applied.
+type NameMapping struct {
+	Aliases map[string]string `json:"aliases"`
+	Rules   []*NameRule       `json:"rules"`
+
+	aliases map[string]string
+}
+
+// NameNormalizer canonicalizes the lens and camera names recorded in
+// EXIF metadata, which vary for the same equipment depending on
+// firmware version and raw converter. A nil NameNormalizer applies
+// only the built-in rules.
+type NameNormalizer struct {
+	Lenses  NameMapping `json:"lenses"`
+	Cameras NameMapping `json:"cameras"`
+}
+
+// builtinLensRules are applied to every lens name, in order, before
+// the user supplied aliases and rules.
+var builtinLensRules = []*NameRule{
+	// "XF16-55mmF2.8" -> "XF16-55mm F2.8"
+	{Match: `^(.*\d)mm\s*F(\d.*)$`, Name: "${1}mm F${2}"},
+	// Fujifilm appends feature designators to lens names in some
+	// firmware versions only - "XF16-55mm F2.8 R LM WR" ->
+	// "XF16-55mm F2.8"
+	{Match: `^(XF\d\S*mm F[\d.]+)(\s+(R|LM|WR|OIS))+$`, Name: "${1}"},
+}
+
+// builtinCameraRules are applied to every camera name, in order,
+// before the user supplied aliases and rules.
+var builtinCameraRules = []*NameRule{
+	// Some converters prefix the model with the make, which the
+	// manufacturers' own firmware does not - "FUJIFILM X-T2" ->
+	// "X-T2"
+	{Match: `(?i)^(FUJIFILM|SONY|OLYMPUS( IMAGING)?( CORP\.?| CORPORATION)?|PANASONIC)\s+(\S.*)$`, Name: "${4}"},
+}
+
+func init() {
+	for _, rules := range [][]*NameRule{builtinLensRules, builtinCameraRules} {
+		if err := compileNameRules(rules); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func compileNameRules(rules []*NameRule) error {
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("Invalid name rule %q: %s", r.Match, err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// Compile prepares the aliases and rules of the normalizer for use,
+// returning an error if any rule is not a valid regular
+// expression. It must be called after modifying the mappings.
+func (n *NameNormalizer) Compile() error {
+	if err := n.Lenses.compile(); err != nil {
+		return err
+	}
+	return n.Cameras.compile()
+}
+
+func (m *NameMapping) compile() error {
+	m.aliases = map[string]string{}
+	for alias, name := range m.Aliases {
+		m.aliases[nameKey(formatName(alias))] = name
+	}
+	return compileNameRules(m.Rules)
+}
+
+// LoadNameNormalizer reads a JSON file of lens and camera aliases and
+// rules, e.g.
+//
+//	{
+//	  "lenses": {
+//	    "aliases": {"16-55mm": "XF16-55mm F2.8"},
+//	    "rules":   [{"match": "^Samyang (\\d+)mm.*", "name": "Samyang ${1}mm"}]
+//	  },
+//	  "cameras": {
+//	    "aliases": {"ILCE-7M3": "Sony A7 III"}
+//	  }
+//	}
+func LoadNameNormalizer(path string) (*NameNormalizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &NameNormalizer{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("Error parsing name mappings %s: %s", path, err)
+	}
+	if err := n.Compile(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// formatName trims and collapses whitespace.
+func formatName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func applyNameRules(name string, rules []*NameRule) string {
+	for _, r := range rules {
+		if r.re == nil {
+			continue
+		}
+		if match := r.re.FindStringSubmatchIndex(name); match != nil {
+			name = string(r.re.ExpandString(nil, r.Name, name, match))
+		}
+	}
+	return name
+}
+
+func (m *NameMapping) normalize(name string, builtins []*NameRule) string {
+	name = applyNameRules(formatName(name), builtins)
+	if m.aliases == nil {
+		// Invalid rules are skipped; use Compile() to detect them
+		m.compile()
+	}
+	if alias, ok := m.aliases[nameKey(name)]; ok {
+		return alias
+	}
+	return applyNameRules(name, m.Rules)
+}
+
+// Lens returns the canonical name of a lens.
+func (n *NameNormalizer) Lens(name string) string {
+	if n == nil {
+		return applyNameRules(formatName(name), builtinLensRules)
+	}
+	return n.Lenses.normalize(name, builtinLensRules)
+}
+
+// Camera returns the canonical name of a camera model.
+func (n *NameNormalizer) Camera(name string) string {
+	if n == nil {
+		return applyNameRules(formatName(name), builtinCameraRules)
+	}
+	return n.Cameras.normalize(name, builtinCameraRules)
+}
+
+// normalizeNamedObjects canonicalizes the names of a list of named
+// objects, keeping only the first object for each canonical name.
+func normalizeNamedObjects(l NamedObjectList, fn func(string) string) NamedObjectList {
+	seen := map[string]bool{}
+	var normalized NamedObjectList
+	for _, o := range l {
+		name := fn(o.Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, &NamedObject{Id: o.Id, Name: name})
+	}
+	return normalized
+}
+
+// normalizeDistribution canonicalizes the labels of a distribution
+// list, merging entries which share a canonical name, and orders it
+// by descending count.
+func normalizeDistribution(l DistributionList, fn func(string) string) DistributionList {
+	for _, e := range l {
+		e.Label = fn(e.Label)
+	}
+	l, _ = collapseDistribution(l, nil)
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Count > l[j].Count
+	})
+	return l
+}
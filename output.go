@@ -0,0 +1,320 @@
+package luminosity
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutputFormat identifies a format for writing stats.
+type OutputFormat string
+
+const (
+	// FormatJSON writes data as a single JSON document.
+	FormatJSON OutputFormat = "json"
+
+	// FormatNDJSON writes one JSON object per line - one per
+	// distribution entry for stats, or one per element for lists.
+	FormatNDJSON OutputFormat = "ndjson"
+
+	// FormatCSV and FormatTSV write distributions as a single table
+	// in long form, with one row per distribution entry and the name
+	// of the distribution in the first column.
+	FormatCSV OutputFormat = "csv"
+	FormatTSV OutputFormat = "tsv"
+
+	// FormatMarkdown writes one table per distribution, each in its
+	// own section.
+	FormatMarkdown OutputFormat = "markdown"
+)
+
+// OutputFormats lists every supported output format.
+var OutputFormats = []OutputFormat{
+	FormatJSON, FormatNDJSON, FormatCSV, FormatTSV, FormatMarkdown,
+}
+
+// ParseOutputFormat returns the output format with the given name.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if name == "md" {
+		return FormatMarkdown, nil
+	}
+	for _, f := range OutputFormats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown output format %q", s)
+}
+
+// Extension returns the conventional file extension of the format,
+// including the leading dot.
+func (f OutputFormat) Extension() string {
+	if f == FormatMarkdown {
+		return ".md"
+	}
+	return "." + string(f)
+}
+
+// IsTabular returns true if the format can only represent
+// distributions.
+func (f OutputFormat) IsTabular() bool {
+	return f == FormatCSV || f == FormatTSV || f == FormatMarkdown
+}
+
+// NamedDistribution is a distribution list and the name it is known
+// by in the JSON output, e.g. "by_camera" or "by_time.month".
+type NamedDistribution struct {
+	Name string
+	List DistributionList
+}
+
+// NamedDistributions returns every distribution of the stats: those
+// of the registered StatsProviders in registration order, followed by
+// Stats.Distributions and the histograms. Nested distributions are
+// named with their path, e.g. "videos.by_frame_rate" or
+// "histograms.by_aperture".
+func (s *Stats) NamedDistributions() []NamedDistribution {
+	dists := s.mergedDistributions()
+	dists = append(dists, namedDistributions("histograms.", s.Histograms)...)
+	return dists
+}
+
+// mergedDistributions returns the distributions of the stats which
+// are merged, i.e. every distribution but the histograms.
+func (s *Stats) mergedDistributions() []NamedDistribution {
+	var dists []NamedDistribution
+	for _, p := range statsProviders {
+		if p.Distributions != nil {
+			dists = append(dists, p.Distributions(s)...)
+		}
+	}
+	return append(dists, namedDistributions("distributions.", s.Distributions)...)
+}
+
+func namedDistributions(prefix string, m map[string]DistributionList) []NamedDistribution {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var dists []NamedDistribution
+	for _, name := range names {
+		dists = append(dists, NamedDistribution{prefix + name, m[name]})
+	}
+	return dists
+}
+
+// tabulate returns the distributions making up data, for the tabular
+// and NDJSON formats.
+func tabulate(data interface{}) ([]NamedDistribution, bool) {
+	switch d := data.(type) {
+	case *Catalog:
+		if d.Stats != nil {
+			return d.Stats.NamedDistributions(), true
+		}
+	case *Stats:
+		return d.NamedDistributions(), true
+	case DistributionList:
+		return []NamedDistribution{{"distribution", d}}, true
+	case []NamedDistribution:
+		return d, true
+	}
+	return nil, false
+}
+
+// OutputWriter writes data in an output format.
+type OutputWriter interface {
+	// Write writes data to w. Tabular formats only support a
+	// *Catalog, *Stats, DistributionList or []NamedDistribution, of
+	// which only the distributions are written, and the rows of a
+	// *QueryResult.
+	Write(w io.Writer, data interface{}) error
+}
+
+// NewOutputWriter returns a writer for the given format. Pretty
+// printing only applies to JSON.
+func NewOutputWriter(format OutputFormat, pretty bool) (OutputWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{pretty}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{}, nil
+	case FormatCSV:
+		return &delimitedWriter{','}, nil
+	case FormatTSV:
+		return &delimitedWriter{'\t'}, nil
+	case FormatMarkdown:
+		return &markdownWriter{}, nil
+	}
+	return nil, fmt.Errorf("Unknown output format %q", format)
+}
+
+type jsonWriter struct {
+	pretty bool
+}
+
+func (jw *jsonWriter) Write(w io.Writer, data interface{}) error {
+	enc := json.NewEncoder(w)
+	if jw.pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(data)
+}
+
+type ndjsonWriter struct{}
+
+// ndjsonEntry is a distribution entry tagged with the name of its
+// distribution.
+type ndjsonEntry struct {
+	Distribution string `json:"distribution"`
+	*DistributionEntry
+}
+
+func (nw *ndjsonWriter) Write(w io.Writer, data interface{}) error {
+	if r, ok := data.(*QueryResult); ok {
+		return r.WriteNDJSON(w)
+	}
+	enc := json.NewEncoder(w)
+	if dists, ok := tabulate(data); ok {
+		for _, d := range dists {
+			for _, e := range d.List {
+				if err := enc.Encode(ndjsonEntry{d.Name, e}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return enc.Encode(data)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catalogColumns returns the sorted names of every catalog the entries
+// of the distributions are attributed to.
+func catalogColumns(dists []NamedDistribution) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, d := range dists {
+		for _, e := range d.List {
+			for name := range e.ByCatalog {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// distributionHeader returns the column names of the tabular formats.
+func distributionHeader(catalogs []string) []string {
+	header := []string{"id", "label", "count", "percent", "cumulative", "cumulative_percent"}
+	for _, name := range catalogs {
+		header = append(header, "count_"+name)
+	}
+	return header
+}
+
+// distributionRow returns the cells of an entry for the tabular
+// formats.
+func distributionRow(e *DistributionEntry, catalogs []string) []string {
+	row := []string{
+		strconv.FormatInt(e.Id, 10),
+		e.Label,
+		strconv.FormatInt(e.Count, 10),
+		strconv.FormatFloat(e.Percent, 'f', -1, 64),
+		strconv.FormatInt(e.Cumulative, 10),
+		strconv.FormatFloat(e.CumulativePercent, 'f', -1, 64),
+	}
+	for _, name := range catalogs {
+		row = append(row, strconv.FormatInt(e.ByCatalog[name], 10))
+	}
+	return row
+}
+
+type delimitedWriter struct {
+	comma rune
+}
+
+func (dw *delimitedWriter) Write(w io.Writer, data interface{}) error {
+	if r, ok := data.(*QueryResult); ok {
+		return r.WriteDelimited(w, dw.comma)
+	}
+	dists, ok := tabulate(data)
+	if !ok {
+		return fmt.Errorf("Cannot write %T as a table", data)
+	}
+	catalogs := catalogColumns(dists)
+	cw := csv.NewWriter(w)
+	cw.Comma = dw.comma
+	if err := cw.Write(append([]string{"distribution"}, distributionHeader(catalogs)...)); err != nil {
+		return err
+	}
+	for _, d := range dists {
+		for _, e := range d.List {
+			if err := cw.Write(append([]string{d.Name}, distributionRow(e, catalogs)...)); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type markdownWriter struct{}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func (mw *markdownWriter) Write(w io.Writer, data interface{}) error {
+	if r, ok := data.(*QueryResult); ok {
+		return r.WriteMarkdown(w)
+	}
+	dists, ok := tabulate(data)
+	if !ok {
+		return fmt.Errorf("Cannot write %T as a table", data)
+	}
+	catalogs := catalogColumns(dists)
+	header := distributionHeader(catalogs)
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	for _, d := range dists {
+		if len(d.List) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "## %s\n\n| %s |\n| %s |\n", d.Name,
+			strings.Join(header, " | "), strings.Join(separator, " | ")); err != nil {
+			return err
+		}
+		for _, e := range d.List {
+			row := distributionRow(e, catalogs)
+			for i, cell := range row {
+				row[i] = markdownEscaper.Replace(cell)
+			}
+			if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | ")); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,8 +15,10 @@ SELECT    image.id_local,
           image.id_global,
           rootfile.baseName,
           rootFolder.absolutePath || folder.pathFromRoot || rootfile.baseName || '.' || rootfile.extension AS fullName,
+          rootfile.extension,
           coalesce(Lens.value, 'Unknown') as Lens,
           coalesce(Camera.Value, 'Unknown') as Camera,
+          CameraSN.value as CameraSerial,
           image.fileFormat,
           image.fileHeight,
           image.fileWidth,
@@ -38,7 +40,9 @@ SELECT    image.id_local,
           exif.gpsLongitude,
           iptc.caption,
           iptc.copyright,
-          coalesce(Creator.value, 'Unknown') as creator
+          coalesce(Creator.value, 'Unknown') as creator,
+          video.duration,
+          video.frame_rate
 `
 	kPhotoRecordFrom = `
 FROM      Adobe_images              image
@@ -49,7 +53,9 @@ LEFT JOIN AgLibraryIPTC             iptc       ON      image.id_local = iptc.ima
 LEFT JOIN AgharvestedExifMetadata   exif       ON      image.id_local = exif.image
 LEFT JOIN AgInternedExifLens        Lens       ON       Lens.id_Local = exif.lensRef
 LEFT JOIN AgInternedExifCameraModel Camera     ON     Camera.id_local = exif.cameraModelRef
+LEFT JOIN AgInternedExifCameraSN    CameraSN   ON   CameraSN.id_local = exif.cameraSNRef
 LEFT JOIN AgInternedIptcCreator     Creator    ON    Creator.id_local = iptc.image
+LEFT JOIN AgVideoInfo               video      ON        video.image = image.id_local
 `
 	kPhotoRecordListOrderBy = "ORDER BY FullName"
 )
@@ -65,16 +71,31 @@ type PhotoRecord struct {
 	Lens     null.String `json:"lens"`
 	Camera   null.String `json:"camera"`
 
+	// CameraMake is inferred from the camera model name, see
+	// CameraMake().
+	CameraMake   null.String `json:"camera_make"`
+	CameraSerial null.String `json:"camera_serial"`
+
 	// Image table
 	FileFormat  string      `json:"file_format"`
 	FileHeight  null.Int    `json:"file_height"`
 	FileWidth   null.Int    `json:"file_width"`
 	Orientation null.String `json:"orientation"`
-	CaptureTime time.Time   `json:"capture_time"`
 	Rating      null.String `json:"rating"`
 	ColorLabels string      `json:"color_labels"`
 	Pick        null.Int    `json:"pick"`
 
+	// CaptureTime is the capture time as recorded by Lightroom,
+	// including the UTC offset when the catalog stores one. When no
+	// offset is recorded the wall-clock time is stored in UTC.
+	CaptureTime time.Time `json:"capture_time"`
+	// CaptureTimeLocal is the wall-clock time at the place and
+	// moment of capture, without any time zone designation.
+	CaptureTimeLocal string `json:"capture_time_local"`
+	// CaptureTimeUTC is the capture time converted to UTC. It is
+	// only valid when the catalog records the offset at capture.
+	CaptureTimeUTC null.Time `json:"capture_time_utc"`
+
 	// Exif
 	DateDay      null.Int    `json:"date_day"`
 	DateMonth    null.Int    `json:"date_month"`
@@ -82,61 +103,107 @@ type PhotoRecord struct {
 	FlashFired   null.Bool   `json:"flash_fired"`
 	ISO          null.String `json:"iso"`
 	ShutterSpeed float64     `json:"shutter_speed"`
-	ExposureTime string      `json:"exposure_time"`
 	FocalLength  null.String `json:"focal_length"`
 	Aperture     float64     `json:"aperture"`
-	FNumber      string      `json:"fnumber"`
-	HasGPS       bool        `json:"has_gps"`
-	Latitude     null.Float  `json:"lat"`
-	Longitude    null.Float  `json:"lon"`
+
+	// ExposureTime and FNumber are rendered snapped to the nearest
+	// standard third stop; the Raw fields hold the exact values in
+	// seconds and as an f-number.
+	ExposureTime    string  `json:"exposure_time"`
+	ExposureTimeRaw float64 `json:"exposure_time_raw"`
+	FNumber         string  `json:"fnumber"`
+	FNumberRaw      float64 `json:"fnumber_raw"`
+
+	// CropFactor and EquivalentFocalLength are only valid when the
+	// crop factor of the camera is known.
+	CropFactor            null.Float `json:"crop_factor"`
+	EquivalentFocalLength null.Float `json:"equivalent_focal_length"`
+
+	HasGPS    bool       `json:"has_gps"`
+	Latitude  null.Float `json:"lat"`
+	Longitude null.Float `json:"lon"`
 
 	// Iptc
 	Caption   null.String `json:"caption"`
 	Copyright null.String `json:"copyright"`
 	Creator   null.String `json:"creator"`
 
+	// SequenceId identifies the burst, bracket or focus stack the
+	// photo is part of, once detected by GetSequenceStats or
+	// DetectSequences. It is 0 for photos which are not part of a
+	// sequence.
+	SequenceId int `json:"sequence_id,omitempty"`
+
+	// Video. Videos have no EXIF metadata; their frame size is
+	// recorded in FileWidth and FileHeight. Duration is in seconds.
+	IsVideo   bool       `json:"is_video"`
+	Duration  null.Float `json:"duration"`
+	FrameRate null.Float `json:"frame_rate"`
+
 	// Pointer back to the catalog that contains this record
 	Catalog *Catalog `json:"-"`
 }
 
-func parseTime(s string) (time.Time, error) {
-	var formats = []string{
-		"2006-01-02T15:04:05+07:00",
-		"2006-01-02T15:04:05",
-	}
-	var err error
-	for _, f := range formats {
-		if t, err := time.Parse(f, s); err == nil {
-			return t, nil
-		}
-	}
-	return time.Time{}, err
-}
-
 func (p *PhotoRecord) scan(row *sql.Rows) error {
 	var capTime null.String
 	var apertureString null.String
 	var shutterSpeedString null.String
+	var extension null.String
+	var hasGPS null.Bool
+	var duration, frameRate null.String
 
 	err := row.Scan(
-		&p.Id, &p.IdGlobal, &p.BaseName, &p.FullName, &p.Lens, &p.Camera,
+		&p.Id, &p.IdGlobal, &p.BaseName, &p.FullName, &extension, &p.Lens, &p.Camera, &p.CameraSerial,
 		// Image
 		&p.FileFormat, &p.FileHeight, &p.FileWidth, &p.Orientation, &capTime, &p.Rating, &p.ColorLabels, &p.Pick,
 		// Exif
 		&p.DateDay, &p.DateMonth, &p.DateYear, &p.FlashFired, &p.ISO, &shutterSpeedString, &p.FocalLength, &apertureString,
-		&p.HasGPS, &p.Latitude, &p.Longitude,
+		&hasGPS, &p.Latitude, &p.Longitude,
 		// Iptc
 		&p.Caption, &p.Copyright, &p.Creator,
+		// Video
+		&duration, &frameRate,
 	)
 	if err != nil {
 		return err
 	}
+	p.HasGPS = hasGPS.Bool
 
-	if capTime.Valid {
-		p.CaptureTime, err = parseTime(capTime.String)
+	p.IsVideo = IsVideoFile(p.FileFormat, extension.String)
+	if d, ok := parseRational(duration.String); duration.Valid && ok {
+		p.Duration = null.FloatFrom(d)
+	}
+	if r, ok := parseRational(frameRate.String); frameRate.Valid && ok {
+		p.FrameRate = null.FloatFrom(r)
+	}
+
+	if p.Catalog != nil {
+		if factor, ok := p.Catalog.cropFactor(p.Camera.String); ok {
+			p.CropFactor = null.FloatFrom(factor)
+			if focalLength, err := strconv.ParseFloat(p.FocalLength.String, 64); err == nil {
+				p.EquivalentFocalLength = null.FloatFrom(focalLength * factor)
+			}
+		}
+
+		names := p.Catalog.StatsOptions.Names
+		p.Lens.String = names.Lens(p.Lens.String)
+		p.Camera.String = names.Camera(p.Camera.String)
+	}
+
+	if cameraMake := CameraMake(p.Camera.String); cameraMake != "" {
+		p.CameraMake = null.StringFrom(cameraMake)
+	}
+
+	if capTime.Valid && capTime.String != "" {
+		t, hasOffset, err := parseCaptureTime(capTime.String)
 		if err != nil {
 			return err
 		}
+		p.CaptureTime = t
+		p.CaptureTimeLocal = t.Format(LocalTimeFormat)
+		if hasOffset {
+			p.CaptureTimeUTC = null.TimeFrom(t.UTC())
+		}
 	}
 
 	if shutterSpeedString.Valid {
@@ -146,6 +213,7 @@ func (p *PhotoRecord) scan(row *sql.Rows) error {
 		}
 		p.ShutterSpeed = shutterSpeed
 		p.ExposureTime = ShutterSpeedToExposureTime(shutterSpeed)
+		p.ExposureTimeRaw = ShutterSpeedToSeconds(shutterSpeed)
 	}
 
 	if apertureString.Valid {
@@ -154,7 +222,8 @@ func (p *PhotoRecord) scan(row *sql.Rows) error {
 			return err
 		}
 		p.Aperture = aperture
-		p.FNumber = fmt.Sprintf("%.1f", ApertureToFNumber(aperture))
+		p.FNumber = ApertureToFNumberString(aperture)
+		p.FNumberRaw = ApertureToFNumber(aperture)
 	}
 	return nil
 }
@@ -199,31 +268,66 @@ func (p *PhotoRecord) OpenPreviewFile() (*PreviewFile, error) {
 
 // ForEachPhoto takes a handler function and calls it successively on
 // a PhotoRecord structure for every photo in the catalog. Returning
-// an error from the handler function will stop the iteration.
+// an error from the handler function will stop the iteration. If the
+// photos have not been loaded by GetPhotos, the records are streamed
+// from the catalog in the order of GetPhotos without being kept in
+// memory, so that very large catalogs can be processed.
 func (c *Catalog) ForEachPhoto(handler func(*PhotoRecord) error) error {
-	if photos, err := c.GetPhotos(); err != nil {
-		return err
-	} else {
-		for _, photo := range photos {
-			if err = handler(photo); err != nil {
+	if c.Photos != nil {
+		for _, photo := range c.Photos {
+			if err := handler(photo); err != nil {
 				return err
 			}
 		}
+		return nil
 	}
-	return nil
+
+	rows, err := c.db.query("for_each_photo",
+		kPhotoRecordSelect+
+			kPhotoRecordFrom+
+			c.photoRecordWhere()+
+			kPhotoRecordListOrderBy)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := &PhotoRecord{
+			Catalog: c,
+		}
+		if err := p.scan(rows); err != nil {
+			return err
+		}
+		if err := handler(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// photoRecordWhere returns the WHERE clause selecting the photo
+// records to load, excluding videos and photos outside of the date
+// window if requested by StatsOptions.
+func (c *Catalog) photoRecordWhere() string {
+	return "WHERE " + c.imageCondition("image.id_local") + "\n"
 }
 
 // GetPhotoCount returns a simple count of the total number of images
-// stored in the catalog.
+// stored in the catalog, excluding videos if StatsOptions.ExcludeVideos
+// is set and photos outside of StatsOptions.Window.
 func (c *Catalog) GetPhotoCount() (int64, error) {
-	row := c.db.queryRow("get_photo_count", "select count(*) "+kPhotoRecordFrom)
+	row := c.db.queryRow("get_photo_count", "select count(*) "+kPhotoRecordFrom+c.photoRecordWhere())
 	var count int64 = -1
 	err := row.Scan(&count)
 	return count, err
 }
 
 // GetPhotos returns an array of PhotoRecord structs for every photo
-// represented in the catalog.
+// represented in the catalog. Videos are included unless
+// StatsOptions.ExcludeVideos is set, and only photos captured within
+// StatsOptions.Window are returned. The records are loaded afresh on
+// every call unless Photos has been set; use ForEachPhoto to process
+// very large catalogs without holding every record in memory.
 func (c *Catalog) GetPhotos() ([]*PhotoRecord, error) {
 	if c.Photos != nil {
 		return c.Photos, nil
@@ -235,6 +339,7 @@ func (c *Catalog) GetPhotos() ([]*PhotoRecord, error) {
 	rows, err := c.db.query("get_photos",
 		kPhotoRecordSelect+
 			kPhotoRecordFrom+
+			c.photoRecordWhere()+
 			kPhotoRecordListOrderBy)
 	if err != nil {
 		return nil, err
@@ -254,3 +359,25 @@ func (c *Catalog) GetPhotos() ([]*PhotoRecord, error) {
 	}
 	return photos, nil
 }
+
+// FrameOrientation returns "landscape", "portrait" or "square"
+// according to the dimensions of the photo as displayed, taking the
+// rotation recorded by Lightroom into account. An empty string is
+// returned when the dimensions are unknown.
+func (p *PhotoRecord) FrameOrientation() string {
+	width, height := p.FileWidth.Int64, p.FileHeight.Int64
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+	if isRotated(p.Orientation.String) {
+		width, height = height, width
+	}
+	switch {
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	default:
+		return "square"
+	}
+}
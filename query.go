@@ -0,0 +1,356 @@
+package luminosity
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// QueryView is a temporary view registered on catalogs opened for
+// ad-hoc queries, exposing Lightroom's tables under friendlier names.
+type QueryView struct {
+	Name        string
+	Description string
+	Query       string
+}
+
+// QueryViews lists the views available to ad-hoc queries. They are
+// built from the same joins as the rest of the library, so also serve
+// as documentation of the Lightroom schema.
+var QueryViews = []QueryView{
+	{
+		Name:        "photos",
+		Description: "One row per photo or video, with file, EXIF, IPTC and video metadata",
+		Query: `
+SELECT    image.id_local                                 AS id,
+          image.id_global                                AS id_global,
+          rootfile.baseName                              AS base_name,
+          rootfile.extension                             AS extension,
+          rootFolder.absolutePath || folder.pathFromRoot AS folder,
+          rootFolder.absolutePath || folder.pathFromRoot || rootfile.baseName || '.' || rootfile.extension AS full_name,
+          Lens.value                                     AS lens,
+          Camera.value                                   AS camera,
+          CameraSN.value                                 AS camera_serial,
+          image.fileFormat                               AS file_format,
+          image.fileWidth                                AS file_width,
+          image.fileHeight                               AS file_height,
+          image.orientation                              AS orientation,
+          image.captureTime                              AS capture_time,
+          image.rating                                   AS rating,
+          image.colorLabels                              AS color_labels,
+          image.pick                                     AS pick,
+          exif.flashFired                                AS flash_fired,
+          exif.isoSpeedRating                            AS iso,
+          exif.shutterSpeed                              AS shutter_speed,
+          exif.focalLength                               AS focal_length,
+          exif.aperture                                  AS aperture,
+          exif.hasGPS                                    AS has_gps,
+          exif.gpsLatitude                               AS latitude,
+          exif.gpsLongitude                              AS longitude,
+          iptc.caption                                   AS caption,
+          iptc.copyright                                 AS copyright,
+          Creator.value                                  AS creator,
+          video.image IS NOT NULL                        AS is_video,
+          video.duration                                 AS duration,
+          video.frame_rate                               AS frame_rate
+` + kPhotoRecordFrom,
+	},
+	{
+		Name:        "keywords",
+		Description: "Keywords, with their parent keyword and number of photos",
+		Query: `
+SELECT    k.id_local AS id,
+          k.name     AS name,
+          k.parent   AS parent,
+          (SELECT count(*) FROM AgLibraryKeywordImage ki WHERE ki.tag = k.id_local) AS photo_count
+FROM      AgLibraryKeyword k
+WHERE     k.name IS NOT NULL
+`,
+	},
+	{
+		Name:        "photo_keywords",
+		Description: "The keywords applied to each photo, joining photos.id to keywords.id",
+		Query: `
+SELECT    ki.image   AS photo_id,
+          k.id_local AS keyword_id,
+          k.name     AS keyword
+FROM      AgLibraryKeywordImage ki
+JOIN      AgLibraryKeyword      k  ON k.id_local = ki.tag
+WHERE     k.name IS NOT NULL
+`,
+	},
+	{
+		Name:        "collections",
+		Description: "Collections, smart collections and collection groups, excluding system collections",
+		Query: `
+SELECT    c.id_local   AS id,
+          c.name       AS name,
+          c.parent     AS parent,
+          c.creationId AS type,
+          (SELECT count(*) FROM AgLibraryCollectionImage ci WHERE ci.collection = c.id_local) AS photo_count
+FROM      AgLibraryCollection c
+WHERE     c.systemOnly = 0
+`,
+	},
+	{
+		Name:        "photo_collections",
+		Description: "The collections containing each photo, joining photos.id to collections.id",
+		Query: `
+SELECT    ci.image     AS photo_id,
+          col.id_local AS collection_id,
+          col.name     AS collection
+FROM      AgLibraryCollectionImage ci
+JOIN      AgLibraryCollection      col ON col.id_local = ci.collection
+WHERE     col.systemOnly = 0
+`,
+	},
+	{
+		Name:        "folders",
+		Description: "Folders, with their full path and number of photos",
+		Query: `
+SELECT    folder.id_local                                AS id,
+          rootFolder.name                                AS root,
+          rootFolder.absolutePath || folder.pathFromRoot AS path,
+          (SELECT count(*) FROM AgLibraryFile f JOIN Adobe_images i ON i.rootFile = f.id_local
+           WHERE f.folder = folder.id_local) AS photo_count
+FROM      AgLibraryFolder     folder
+JOIN      AgLibraryRootFolder rootFolder ON rootFolder.id_local = folder.rootFolder
+`,
+	},
+	{
+		Name:        "history",
+		Description: "Develop history steps of each photo, oldest first",
+		Query: `
+SELECT    id_local    AS id,
+          image       AS photo_id,
+          name        AS name,
+          dateCreated AS date_created
+FROM      Adobe_libraryImageDevelopHistoryStep
+ORDER BY  image, dateCreated, id_local
+`,
+	},
+}
+
+// OpenQueryCatalog opens a catalog read-only for ad-hoc SQL queries,
+// with the QueryViews registered as temporary views. Queries cannot
+// modify the catalog, nor create further temporary objects.
+func OpenQueryCatalog(path string) (*Catalog, error) {
+	c, err := OpenCatalogReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range QueryViews {
+		if _, err := c.db.Exec(fmt.Sprintf("CREATE TEMP VIEW %s AS %s", v.Name, v.Query)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("Error creating view %s: %s", v.Name, err)
+		}
+	}
+	if _, err := c.db.Exec("PRAGMA query_only = 1"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"action":  "create_query_views",
+		"catalog": path,
+		"views":   len(QueryViews),
+	}).Debug()
+	return c, nil
+}
+
+// QueryResult holds the rows returned by an ad-hoc query. Text
+// columns are returned as strings, and NULL as nil.
+type QueryResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Query runs an ad-hoc SQL statement against the catalog, which
+// should have been opened with OpenQueryCatalog.
+func (c *Catalog) Query(query string) (*QueryResult, error) {
+	rows, err := c.db.query("ad_hoc_query", query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &QueryResult{Columns: columns}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valueptrs := make([]interface{}, len(columns))
+		for i := range values {
+			valueptrs[i] = &values[i]
+		}
+		if err := rows.Scan(valueptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok && utf8.Valid(b) {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.Err()
+}
+
+// WithCatalogColumn returns a copy of the result with a leading
+// "catalog" column set to name on every row.
+func (r *QueryResult) WithCatalogColumn(name string) *QueryResult {
+	result := &QueryResult{
+		Columns: append([]string{"catalog"}, r.Columns...),
+		Rows:    make([][]interface{}, len(r.Rows)),
+	}
+	for i, row := range r.Rows {
+		result.Rows[i] = append([]interface{}{name}, row...)
+	}
+	return result
+}
+
+// Append adds the rows of another result to r. Both results must
+// have the same columns.
+func (r *QueryResult) Append(other *QueryResult) error {
+	if r.Columns == nil {
+		r.Columns = other.Columns
+	} else if !reflect.DeepEqual(r.Columns, other.Columns) {
+		return fmt.Errorf("Query results have different columns: %s and %s",
+			strings.Join(r.Columns, ", "), strings.Join(other.Columns, ", "))
+	}
+	r.Rows = append(r.Rows, other.Rows...)
+	return nil
+}
+
+// formatQueryValue renders a value for the text and delimited
+// formats, with NULL as an empty string.
+func formatQueryValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return fmt.Sprintf("%x", x)
+	}
+	return fmt.Sprint(v)
+}
+
+var textCellEscaper = strings.NewReplacer("\t", " ", "\n", " ")
+
+// WriteText writes the result as aligned columns, with a header line.
+func (r *QueryResult) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(r.Columns, "\t"))
+	for _, row := range r.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = textCellEscaper.Replace(formatQueryValue(v))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteDelimited writes the result as CSV, separated by comma, with a
+// header line.
+func (r *QueryResult) WriteDelimited(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(r.Columns); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = formatQueryValue(v)
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// rowJSON returns a row as a JSON object, with one key per column in
+// the order of the query.
+func (r *QueryResult) rowJSON(row []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, v := range row {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(r.Columns[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON renders the result as an array of objects, one per row.
+func (r *QueryResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range r.Rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		data, err := r.rowJSON(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	buf.WriteString("]")
+	return buf.Bytes(), nil
+}
+
+// WriteNDJSON writes the result as one JSON object per row.
+func (r *QueryResult) WriteNDJSON(w io.Writer) error {
+	for _, row := range r.Rows {
+		data, err := r.rowJSON(row)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMarkdown writes the result as a Markdown table.
+func (r *QueryResult) WriteMarkdown(w io.Writer) error {
+	separator := make([]string, len(r.Columns))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n| %s |\n",
+		strings.Join(r.Columns, " | "), strings.Join(separator, " | ")); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = markdownEscaper.Replace(formatQueryValue(v))
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
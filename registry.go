@@ -0,0 +1,377 @@
+package luminosity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StatsProvider computes one part of the stats of a catalog - usually
+// a single distribution - and merges it. GetStats runs every
+// registered provider selected by StatsOptions, in registration
+// order, and Stats.Merge merges the results of all of them.
+type StatsProvider struct {
+	// Name identifies the provider in StatsOptions.Distributions. For
+	// distributions it is also their JSON key, e.g. "by_camera".
+	Name string
+
+	// Compute computes the provider's part of the stats of a catalog
+	// and stores it in s.
+	Compute func(c *Catalog, s *Stats) error
+
+	// Merge adds the provider's part of other into s.
+	Merge func(s, other *Stats)
+
+	// Distributions optionally returns the distribution lists the
+	// provider stores in s, named as in the JSON output. It is used
+	// to write tabular output and to attribute counts to catalogs.
+	// Distributions stored in Stats.Distributions are handled by
+	// Stats.NamedDistributions and need not be returned.
+	Distributions func(s *Stats) []NamedDistribution
+}
+
+var statsProviders []*StatsProvider
+
+// RegisterStatsProvider adds a provider to the registry, after the
+// built-in ones. Provider names must be unique.
+func RegisterStatsProvider(p *StatsProvider) error {
+	if p.Name == "" || p.Compute == nil || p.Merge == nil {
+		return fmt.Errorf("Stats provider %q must have a name, Compute and Merge", p.Name)
+	}
+	if FindStatsProvider(p.Name) != nil {
+		return fmt.Errorf("Stats provider %q is already registered", p.Name)
+	}
+	statsProviders = append(statsProviders, p)
+	return nil
+}
+
+// StatsProviders returns every registered provider, in order.
+func StatsProviders() []*StatsProvider {
+	return append([]*StatsProvider{}, statsProviders...)
+}
+
+// StatsProviderNames returns the names of every registered provider,
+// in order.
+func StatsProviderNames() []string {
+	names := make([]string, len(statsProviders))
+	for i, p := range statsProviders {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// FindStatsProvider returns the registered provider with the given
+// name, which may omit the "by_" prefix of distribution names, or nil
+// if there is none.
+func FindStatsProvider(name string) *StatsProvider {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, p := range statsProviders {
+		if p.Name == name || p.Name == "by_"+name {
+			return p
+		}
+	}
+	return nil
+}
+
+// selectedProviders returns the registered providers selected by
+// StatsOptions.Distributions and StatsOptions.ExcludeDistributions,
+// in registration order.
+func (o StatsOptions) selectedProviders() ([]*StatsProvider, error) {
+	lookup := func(names []string) (map[*StatsProvider]bool, error) {
+		m := map[*StatsProvider]bool{}
+		for _, name := range names {
+			p := FindStatsProvider(name)
+			if p == nil {
+				return nil, fmt.Errorf("Unknown distribution %q (expected one of %s)",
+					name, strings.Join(StatsProviderNames(), ", "))
+			}
+			m[p] = true
+		}
+		return m, nil
+	}
+	included, err := lookup(o.Distributions)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := lookup(o.ExcludeDistributions)
+	if err != nil {
+		return nil, err
+	}
+	var selected []*StatsProvider
+	for _, p := range statsProviders {
+		if (len(included) == 0 || included[p]) && !excluded[p] {
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil
+}
+
+// ----------------------------------------------------------------------
+// Distribution providers
+// ----------------------------------------------------------------------
+
+func distributionProvider(name string, compute func(c *Catalog) (DistributionList, error),
+	order func(DistributionList), get func(s *Stats) DistributionList, set func(s *Stats, l DistributionList)) *StatsProvider {
+	return &StatsProvider{
+		Name: name,
+		Compute: func(c *Catalog, s *Stats) error {
+			if d, err := compute(c); err != nil {
+				return err
+			} else {
+				set(s, d)
+			}
+			return nil
+		},
+		Merge: func(s, other *Stats) {
+			merged := get(s).Merge(get(other))
+			if order != nil {
+				order(merged)
+			}
+			set(s, merged)
+		},
+	}
+}
+
+// builtinDistribution returns a provider for a distribution stored in
+// a field of Stats.
+func builtinDistribution(name string, compute func(c *Catalog) (DistributionList, error),
+	order func(DistributionList), field func(s *Stats) *DistributionList) *StatsProvider {
+	p := distributionProvider(name, compute, order,
+		func(s *Stats) DistributionList { return *field(s) },
+		func(s *Stats, l DistributionList) { *field(s) = l })
+	p.Distributions = func(s *Stats) []NamedDistribution {
+		return []NamedDistribution{{name, *field(s)}}
+	}
+	return p
+}
+
+// NewDistributionProvider returns a provider for a distribution
+// computed by an arbitrary function, stored in Stats.Distributions
+// under the given name. If order is set, it restores the order of the
+// distribution after merging.
+func NewDistributionProvider(name string, compute func(c *Catalog) (DistributionList, error),
+	order func(DistributionList)) *StatsProvider {
+	return distributionProvider(name, compute, order,
+		func(s *Stats) DistributionList { return s.Distributions[name] },
+		func(s *Stats, l DistributionList) {
+			if s.Distributions == nil {
+				s.Distributions = map[string]DistributionList{}
+			}
+			s.Distributions[name] = l
+		})
+}
+
+// NewSQLDistributionProvider returns a provider for a distribution
+// computed by an SQL query returning the id, label and count of each
+// entry. If imageColumn is set, the query must contain a single %s
+// verb, which is replaced by a condition on that column restricting
+// the images according to StatsOptions (see ExcludeVideos and Window).
+func NewSQLDistributionProvider(name, query, imageColumn string) *StatsProvider {
+	return NewDistributionProvider(name, func(c *Catalog) (DistributionList, error) {
+		sql := query
+		if imageColumn != "" {
+			sql = fmt.Sprintf(query, c.imageCondition(imageColumn))
+		}
+		return c.queryDistribution(sql, defaultDistributionConvertor)
+	}, nil)
+}
+
+// NewPhotoDistributionProvider returns a provider for a distribution
+// aggregated over the photo records of the catalog. The key function
+// returns the id and label of the entry a photo is counted in, or
+// false to leave the photo out. Merged distributions are ordered by
+// id.
+func NewPhotoDistributionProvider(name string, key func(p *PhotoRecord) (int64, string, bool)) *StatsProvider {
+	order := func(l DistributionList) { sort.Sort(byId(l)) }
+	return NewDistributionProvider(name, func(c *Catalog) (DistributionList, error) {
+		photos, err := c.statsPhotos()
+		if err != nil {
+			return nil, err
+		}
+		m := DistributionMap{}
+		for _, p := range photos {
+			if id, label, ok := key(p); ok {
+				m.add(id, label, 1)
+			}
+		}
+		l := m.ToList()
+		order(l)
+		return l, nil
+	}, order)
+}
+
+// ----------------------------------------------------------------------
+// Built-in providers
+// ----------------------------------------------------------------------
+
+func sortById(l DistributionList) { sort.Sort(byId(l)) }
+
+func init() {
+	statsProviders = []*StatsProvider{
+		builtinDistribution("by_date", func(c *Catalog) (DistributionList, error) {
+			d, err := c.GetPhotoCountsByDate()
+			if err == nil && c.StatsOptions.FillEmptyBuckets {
+				d = TimeBucketDay.Fill(d)
+			}
+			return d, err
+		}, func(l DistributionList) { sort.Sort(ByDate(l)) },
+			func(s *Stats) *DistributionList { return &s.ByDate }),
+		{
+			Name: "by_time",
+			Compute: func(c *Catalog, s *Stats) error {
+				if len(c.StatsOptions.TimeBuckets) == 0 {
+					return nil
+				}
+				if d, err := c.GetTimeDistributions(c.StatsOptions.TimeBuckets...); err != nil {
+					return err
+				} else {
+					s.ByTime = d
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) {
+				for name, dist := range other.ByTime {
+					if s.ByTime == nil {
+						s.ByTime = map[string]DistributionList{}
+					}
+					merged := s.ByTime[name].Merge(dist)
+					if bucket, err := ParseTimeBucket(name); err == nil {
+						bucket.Sort(merged)
+					}
+					s.ByTime[name] = merged
+				}
+			},
+			Distributions: func(s *Stats) []NamedDistribution {
+				return namedDistributions("by_time.", s.ByTime)
+			},
+		},
+		builtinDistribution("by_camera", (*Catalog).GetCameraDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByCamera }),
+		builtinDistribution("by_lens", (*Catalog).GetLensDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByLens }),
+		builtinDistribution("by_focal_length", (*Catalog).GetFocalLengthDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByFocalLength }),
+		builtinDistribution("by_aperture", (*Catalog).GetApertureDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByAperture }),
+		builtinDistribution("by_exposure_time", (*Catalog).GetExposureTimeDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByExposureTime }),
+		builtinDistribution("by_edit_count", (*Catalog).GetEditCountDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByEditCount }),
+		builtinDistribution("by_keyword", (*Catalog).GetKeywordDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByKeyword }),
+		builtinDistribution("by_iso", (*Catalog).GetISODistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByISO }),
+		builtinDistribution("by_exposure_value", (*Catalog).GetExposureValueDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByExposureValue }),
+		builtinDistribution("by_light_value", (*Catalog).GetLightValueDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByLightValue }),
+		builtinDistribution("by_aperture_exposure_time", (*Catalog).GetApertureExposureTimeDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByApertureExposureTime }),
+		builtinDistribution("by_iso_focal_length", (*Catalog).GetISOFocalLengthDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByISOFocalLength }),
+		{
+			// Also computes UnknownCropFactorCameras
+			Name: "by_equivalent_focal_length",
+			Compute: func(c *Catalog, s *Stats) error {
+				if d, u, err := c.GetEquivalentFocalLengthDistribution(); err != nil {
+					return err
+				} else {
+					s.ByEquivalentFocalLength = d
+					s.UnknownCropFactorCameras = u
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) {
+				s.ByEquivalentFocalLength = s.ByEquivalentFocalLength.Merge(other.ByEquivalentFocalLength)
+				s.UnknownCropFactorCameras = s.UnknownCropFactorCameras.Merge(other.UnknownCropFactorCameras)
+				sort.Sort(byId(s.ByEquivalentFocalLength))
+			},
+			Distributions: func(s *Stats) []NamedDistribution {
+				return []NamedDistribution{
+					{"by_equivalent_focal_length", s.ByEquivalentFocalLength},
+					{"unknown_crop_factor_cameras", s.UnknownCropFactorCameras},
+				}
+			},
+		},
+		builtinDistribution("by_rating", (*Catalog).GetRatingDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByRating }),
+		builtinDistribution("by_pick", (*Catalog).GetPickDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByPick }),
+		builtinDistribution("by_color_label", (*Catalog).GetColorLabelDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByColorLabel }),
+		{
+			Name: "keeper_rates",
+			Compute: func(c *Catalog, s *Stats) error {
+				if k, err := c.GetKeeperRates(); err != nil {
+					return err
+				} else {
+					s.KeeperRates = k
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) { s.KeeperRates.Merge(other.KeeperRates) },
+		},
+		{
+			Name: "sessions",
+			Compute: func(c *Catalog, s *Stats) error {
+				if sessions, err := c.GetSessions(); err != nil {
+					return err
+				} else {
+					s.Sessions = sessions
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) { s.Sessions.Merge(other.Sessions) },
+		},
+		{
+			Name: "sequences",
+			Compute: func(c *Catalog, s *Stats) error {
+				if sequences, err := c.GetSequenceStats(); err != nil {
+					return err
+				} else {
+					s.Sequences = sequences
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) { s.Sequences.Merge(other.Sequences) },
+			Distributions: func(s *Stats) []NamedDistribution {
+				if s.Sequences == nil {
+					return nil
+				}
+				return []NamedDistribution{
+					{"sequences.by_type", s.Sequences.ByType},
+					{"sequences.by_frames", s.Sequences.ByFrames},
+				}
+			},
+		},
+		{
+			Name: "videos",
+			Compute: func(c *Catalog, s *Stats) error {
+				if v, err := c.GetVideoStats(); err != nil {
+					return err
+				} else {
+					s.Videos = v
+				}
+				return nil
+			},
+			Merge: func(s, other *Stats) { s.Videos.Merge(other.Videos) },
+			Distributions: func(s *Stats) []NamedDistribution {
+				if s.Videos == nil {
+					return nil
+				}
+				return []NamedDistribution{
+					{"videos.by_frame_size", s.Videos.ByFrameSize},
+					{"videos.by_frame_rate", s.Videos.ByFrameRate},
+				}
+			},
+		},
+		builtinDistribution("by_file_format", (*Catalog).GetFileFormatDistribution, nil,
+			func(s *Stats) *DistributionList { return &s.ByFileFormat }),
+		builtinDistribution("by_megapixels", (*Catalog).GetMegapixelDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByMegapixels }),
+		builtinDistribution("by_orientation", (*Catalog).GetOrientationDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByOrientation }),
+		builtinDistribution("by_aspect_ratio", (*Catalog).GetAspectRatioDistribution, sortById,
+			func(s *Stats) *DistributionList { return &s.ByAspectRatio }),
+	}
+}
@@ -0,0 +1,254 @@
+package luminosity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// GetFileFormatDistribution returns a distribution list indicating
+// the number of photos of each file format recorded by Lightroom
+// (RAW, DNG, JPG, TIFF, VIDEO, etc...).
+func (c *Catalog) GetFileFormatDistribution() (DistributionList, error) {
+	const query = `
+SELECT   0,
+         coalesce(nullif(fileFormat, ''), 'unknown') as format,
+         count(*)
+FROM     Adobe_images
+WHERE    %s
+GROUP BY format
+ORDER BY format
+`
+	return c.queryDistribution(fmt.Sprintf(query, c.imageCondition("id_local")), defaultDistributionConvertor)
+}
+
+// frameSize is one distinct combination of original file dimensions,
+// rotation and crop, with the number of photos sharing it.
+type frameSize struct {
+	fileWidth     null.Float
+	fileHeight    null.Float
+	orientation   null.String
+	croppedWidth  null.Float
+	croppedHeight null.Float
+	count         int64
+}
+
+// isRotated returns true when Lightroom displays the image rotated by
+// 90 degrees, swapping its width and height. Lightroom records
+// rotation as the edges of the original image shown at the top and
+// right, e.g. AB when unrotated and BC for 90° clockwise.
+func isRotated(orientation string) bool {
+	switch orientation {
+	case "BC", "DA", "CB", "AD":
+		return true
+	}
+	return false
+}
+
+// megapixels returns the resolution of the original file in
+// megapixels.
+func (f frameSize) megapixels() (float64, bool) {
+	if !f.fileWidth.Valid || !f.fileHeight.Valid || f.fileWidth.Float64 <= 0 || f.fileHeight.Float64 <= 0 {
+		return 0, false
+	}
+	return f.fileWidth.Float64 * f.fileHeight.Float64 / 1e6, true
+}
+
+// displayed returns the width and height of the image as displayed,
+// after cropping when develop settings are available, and rotation.
+func (f frameSize) displayed() (float64, float64, bool) {
+	width, height := f.fileWidth.Float64, f.fileHeight.Float64
+	if f.croppedWidth.Valid && f.croppedHeight.Valid && f.croppedWidth.Float64 > 0 && f.croppedHeight.Float64 > 0 {
+		width, height = f.croppedWidth.Float64, f.croppedHeight.Float64
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	if isRotated(f.orientation.String) {
+		width, height = height, width
+	}
+	return width, height, true
+}
+
+// getFrameSizes returns the number of photos with each distinct
+// combination of file dimensions, rotation and crop.
+func (c *Catalog) getFrameSizes() ([]frameSize, error) {
+	const query = `
+SELECT    image.fileWidth,
+          image.fileHeight,
+          image.orientation,
+          develop.croppedWidth,
+          develop.croppedHeight,
+          count(*)
+FROM      Adobe_images               image
+LEFT JOIN Adobe_imageDevelopSettings develop ON develop.image = image.id_local
+WHERE     %s
+GROUP BY  image.fileWidth, image.fileHeight, image.orientation,
+          develop.croppedWidth, develop.croppedHeight
+`
+	rows, err := c.db.query("get_frame_sizes", fmt.Sprintf(query, c.imageCondition("image.id_local")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sizes []frameSize
+	for rows.Next() {
+		var f frameSize
+		if err := rows.Scan(&f.fileWidth, &f.fileHeight, &f.orientation,
+			&f.croppedWidth, &f.croppedHeight, &f.count); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, f)
+	}
+	return sizes, rows.Err()
+}
+
+// statsFrameSizes returns the frame sizes of the catalog. While
+// GetStats is running they are queried only once and shared between
+// the distributions derived from them.
+func (c *Catalog) statsFrameSizes() ([]frameSize, error) {
+	if c.run == nil {
+		return c.getFrameSizes()
+	}
+	if !c.run.frameSizesLoaded {
+		sizes, err := c.getFrameSizes()
+		if err != nil {
+			return nil, err
+		}
+		c.run.frameSizes, c.run.frameSizesLoaded = sizes, true
+	}
+	return c.run.frameSizes, nil
+}
+
+// frameDistribution aggregates the frame sizes of the catalog into a
+// distribution list ordered by Id, using the key function to compute
+// the Id and label of each frame size. Sizes for which the key
+// function returns false are skipped.
+func (c *Catalog) frameDistribution(key func(f frameSize) (int64, string, bool)) (DistributionList, error) {
+	sizes, err := c.statsFrameSizes()
+	if err != nil {
+		return nil, err
+	}
+	m := DistributionMap{}
+	for _, f := range sizes {
+		if id, label, ok := key(f); ok {
+			m.add(id, label, f.count)
+		}
+	}
+	l := m.ToList()
+	sort.Sort(byId(l))
+	return l, nil
+}
+
+// MegapixelBuckets are the lower bounds, in megapixels, of the
+// resolution ranges used by GetMegapixelDistribution.
+var MegapixelBuckets = []float64{0, 2, 4, 8, 12, 16, 20, 24, 30, 36, 45, 50, 60, 100}
+
+// MegapixelBucket returns the label of the MegapixelBuckets range
+// containing a resolution, e.g. "24-30MP", and its lower bound.
+func MegapixelBucket(mp float64) (float64, string) {
+	i := sort.Search(len(MegapixelBuckets), func(i int) bool {
+		return MegapixelBuckets[i] > mp
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	lower := MegapixelBuckets[i]
+	if i == len(MegapixelBuckets)-1 {
+		return lower, fmt.Sprintf("%gMP+", lower)
+	}
+	return lower, fmt.Sprintf("%g-%gMP", lower, MegapixelBuckets[i+1])
+}
+
+// GetMegapixelDistribution returns a distribution list indicating the
+// number of photos in each range of original file resolution (see
+// MegapixelBuckets). Entry ids are the lower bounds of the ranges.
+func (c *Catalog) GetMegapixelDistribution() (DistributionList, error) {
+	return c.frameDistribution(func(f frameSize) (int64, string, bool) {
+		mp, ok := f.megapixels()
+		if !ok {
+			return 0, "", false
+		}
+		lower, label := MegapixelBucket(mp)
+		return int64(lower), label, true
+	})
+}
+
+// GetOrientationDistribution returns a distribution list indicating
+// the number of landscape, portrait and square photos, as displayed
+// after cropping and rotation.
+func (c *Catalog) GetOrientationDistribution() (DistributionList, error) {
+	return c.frameDistribution(func(f frameSize) (int64, string, bool) {
+		width, height, ok := f.displayed()
+		if !ok {
+			return 0, "", false
+		}
+		switch {
+		case width > height:
+			return 0, "landscape", true
+		case width < height:
+			return 1, "portrait", true
+		default:
+			return 2, "square", true
+		}
+	})
+}
+
+// standardAspectRatios are the aspect ratios photos are snapped to
+// by AspectRatioLabel, as long side to short side.
+var standardAspectRatios = []struct {
+	ratio float64
+	label string
+}{
+	{1, "1:1"},
+	{5.0 / 4, "5:4"},
+	{4.0 / 3, "4:3"},
+	{7.0 / 5, "7:5"},
+	{3.0 / 2, "3:2"},
+	{16.0 / 10, "16:10"},
+	{16.0 / 9, "16:9"},
+	{2, "2:1"},
+	{2.39, "2.39:1"},
+	{3, "3:1"},
+}
+
+// aspectRatioTolerance is the relative difference within which an
+// aspect ratio is considered to be a standard ratio.
+const aspectRatioTolerance = 0.01
+
+// AspectRatioLabel returns the label of the aspect ratio of an image
+// of the given dimensions, regardless of its orientation. Ratios
+// within 1% of a standard ratio are labeled as the standard ratio
+// (e.g. "3:2"); others are labeled by their value rounded to two
+// decimals (e.g. "1.85:1"). The ratio of the long side to the short
+// side is returned as well.
+func AspectRatioLabel(width, height float64) (float64, string) {
+	ratio := math.Max(width, height) / math.Min(width, height)
+	for _, r := range standardAspectRatios {
+		if math.Abs(ratio-r.ratio)/r.ratio <= aspectRatioTolerance {
+			return r.ratio, r.label
+		}
+	}
+	ratio = math.Round(ratio*100) / 100
+	return ratio, strconv.FormatFloat(ratio, 'f', -1, 64) + ":1"
+}
+
+// GetAspectRatioDistribution returns a distribution list indicating
+// the number of photos with each aspect ratio (see
+// AspectRatioLabel). The aspect ratio is taken after cropping when
+// develop settings are available. Entry ids are the ratios
+// multiplied by 100.
+func (c *Catalog) GetAspectRatioDistribution() (DistributionList, error) {
+	return c.frameDistribution(func(f frameSize) (int64, string, bool) {
+		width, height, ok := f.displayed()
+		if !ok {
+			return 0, "", false
+		}
+		ratio, label := AspectRatioLabel(width, height)
+		return int64(math.Round(ratio * 100)), label, true
+	})
+}
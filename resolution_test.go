@@ -0,0 +1,62 @@
+package luminosity
+
+import "testing"
+
+func TestAspectRatioLabel(t *testing.T) {
+	tests := []struct {
+		width, height float64
+		ratio         float64
+		label         string
+	}{
+		{6000, 4000, 1.5, "3:2"},
+		{4000, 6000, 1.5, "3:2"},
+		{4000, 4000, 1, "1:1"},
+		{4032, 3024, 4.0 / 3, "4:3"},
+		{1920, 1080, 16.0 / 9, "16:9"},
+		{2560, 1600, 1.6, "16:10"},
+		{5000, 4000, 1.25, "5:4"},
+		{7000, 5000, 1.4, "7:5"},
+		{6000, 3000, 2, "2:1"},
+		{9000, 3000, 3, "3:1"},
+		// Within 1% of a standard ratio
+		{6000, 3990, 1.5, "3:2"},
+		{2390, 1000, 2.39, "2.39:1"},
+		// Non-standard ratios are rounded to two decimals
+		{1850, 1000, 1.85, "1.85:1"},
+		{1000, 900, 1.11, "1.11:1"},
+	}
+	for _, test := range tests {
+		ratio, label := AspectRatioLabel(test.width, test.height)
+		if ratio != test.ratio || label != test.label {
+			t.Errorf("AspectRatioLabel(%g, %g) = %g, %q, want %g, %q",
+				test.width, test.height, ratio, label, test.ratio, test.label)
+		}
+	}
+}
+
+func TestMegapixelBucket(t *testing.T) {
+	tests := []struct {
+		mp    float64
+		lower float64
+		label string
+	}{
+		{0, 0, "0-2MP"},
+		{1.9, 0, "0-2MP"},
+		{2, 2, "2-4MP"},
+		{12.2, 12, "12-16MP"},
+		{24, 24, "24-30MP"},
+		{24.2, 24, "24-30MP"},
+		{45.7, 45, "45-50MP"},
+		{61, 60, "60-100MP"},
+		{100, 100, "100MP+"},
+		{150, 100, "100MP+"},
+		{-1, 0, "0-2MP"},
+	}
+	for _, test := range tests {
+		lower, label := MegapixelBucket(test.mp)
+		if lower != test.lower || label != test.label {
+			t.Errorf("MegapixelBucket(%g) = %g, %q, want %g, %q",
+				test.mp, lower, label, test.lower, test.label)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package luminosity
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SearchIndexVersion is the version of the search index format. It
+// must be incremented whenever the indexed content changes, so that
+// older indexes are rebuilt.
+const SearchIndexVersion = 2
+
+// SearchIndexSuffix is appended to the catalog name to form the path
+// of its search index, which is stored next to the catalog.
+const SearchIndexSuffix = " Luminosity Search.db"
+
+// searchColumn is a column of the full-text index, and the weight of
+// matches in it when ranking results.
+type searchColumn struct {
+	name   string
+	weight float64
+}
+
+var searchColumns = []searchColumn{
+	{"caption", 1},
+	{"title", 2},
+	{"headline", 1.5},
+	{"keywords", 2},
+	{"collections", 1},
+	{"base_name", 1},
+	{"folder", 0.5},
+}
+
+// SearchIndexPath returns the path of the search index of a catalog.
+func SearchIndexPath(catalogPath string) string {
+	dir, file := filepath.Split(catalogPath)
+	return filepath.Join(dir, strings.TrimSuffix(file, CatalogExtension)+SearchIndexSuffix)
+}
+
+// SearchOptions controls how catalogs are searched.
+type SearchOptions struct {
+	// Limit is the maximum number of results returned, or 0 for all.
+	Limit int
+
+	// Refresh rebuilds the index even if it is up to date.
+	Refresh bool
+}
+
+// SearchResult is a photo matching a full-text search. Higher scores
+// are better matches. Scores are relative to the best match of the
+// same catalog, which scores 1, as the raw scores of each catalog's
+// index depend on the term frequencies of that catalog alone.
+type SearchResult struct {
+	CatalogName string  `json:"catalog"`
+	Score       float64 `json:"score"`
+	*PhotoRecord
+}
+
+// Search returns the photos of the catalog matching a full-text
+// query over captions, titles, headlines, keywords, collection names,
+// base names and folder paths, best matches first. The query uses the
+// SQLite full-text query syntax, e.g. beach sunset, "family dinner" or
+// port*.
+//
+// The index is kept in its own file next to the catalog (see
+// SearchIndexPath), and is rebuilt whenever the catalog has changed
+// since it was built. The catalog itself is only ever read.
+func (c *Catalog) Search(query string, options SearchOptions) ([]*SearchResult, error) {
+	idx, err := c.openSearchIndex(options.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
+	scores, err := idx.search(query)
+	if err != nil {
+		return nil, fmt.Errorf("Error searching %s: %s", c.Path(), err)
+	}
+	photos, err := c.getPhotosById(scores)
+	if err != nil {
+		return nil, err
+	}
+	best := 0.0
+	for _, score := range scores {
+		best = math.Max(best, score)
+	}
+	var results []*SearchResult
+	for id, score := range scores {
+		if p, ok := photos[id]; ok {
+			if best > 0 {
+				score /= best
+			}
+			results = append(results, &SearchResult{
+				CatalogName: c.Name(),
+				Score:       score,
+				PhotoRecord: p,
+			})
+		}
+	}
+	return RankSearchResults(results, options.Limit), nil
+}
+
+// RankSearchResults sorts search results by decreasing score, e.g.
+// after gathering the results of several catalogs, and keeps the
+// first limit results if limit is greater than 0.
+func RankSearchResults(results []*SearchResult, limit int) []*SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].CatalogName != results[j].CatalogName {
+			return results[i].CatalogName < results[j].CatalogName
+		}
+		return results[i].FullName < results[j].FullName
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// getPhotosById returns the photo records with the given ids, keyed
+// by id.
+func (c *Catalog) getPhotosById(ids map[int]float64) (map[int]*PhotoRecord, error) {
+	photos := map[int]*PhotoRecord{}
+	if len(ids) == 0 {
+		return photos, nil
+	}
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, strconv.Itoa(id))
+	}
+	where := fmt.Sprintf("WHERE image.id_local IN (%s)\n", strings.Join(list, ","))
+	rows, err := c.db.query("get_photos_by_id", kPhotoRecordSelect+kPhotoRecordFrom+where)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := &PhotoRecord{
+			Catalog: c,
+		}
+		if err := p.scan(rows); err != nil {
+			return nil, err
+		}
+		photos[p.Id] = p
+	}
+	return photos, rows.Err()
+}
+
+// searchIndex is the full-text index of a catalog.
+type searchIndex struct {
+	db *DB
+
+	// module is the SQLite full-text module of the index, fts5 if
+	// compiled in, fts4 otherwise.
+	module string
+}
+
+func (idx *searchIndex) Close() error {
+	return idx.db.Close()
+}
+
+// searchIndexMeta identifies the catalog an index was built from, to
+// tell when it must be rebuilt.
+type searchIndexMeta struct {
+	version int
+	module  string
+	modTime time.Time
+	size    int64
+}
+
+func (m searchIndexMeta) values() map[string]string {
+	return map[string]string{
+		"version":  strconv.Itoa(m.version),
+		"module":   m.module,
+		"mod_time": m.modTime.UTC().Format(time.RFC3339Nano),
+		"size":     strconv.FormatInt(m.size, 10),
+	}
+}
+
+// ftsModule returns the best full-text module compiled into SQLite.
+// FTS5 requires building with the sqlite_fts5 tag; FTS4 is always
+// available.
+func ftsModule(db *DB) string {
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err == nil && fts5 {
+		return "fts5"
+	}
+	return "fts4"
+}
+
+// openSearchIndex opens the search index of the catalog, building it
+// first if it is missing, out of date or refresh is set.
+func (c *Catalog) openSearchIndex(refresh bool) (*searchIndex, error) {
+	info, err := os.Stat(c.Path())
+	if err != nil {
+		return nil, err
+	}
+	path := SearchIndexPath(c.Path())
+	want := searchIndexMeta{
+		version: SearchIndexVersion,
+		module:  ftsModule(c.db),
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+
+	if !refresh {
+		if idx, ok := openCurrentSearchIndex(path, want); ok {
+			return idx, nil
+		}
+	}
+	if err := c.buildSearchIndex(path, want); err != nil {
+		return nil, fmt.Errorf("Error building search index %s: %s", path, err)
+	}
+	if idx, ok := openCurrentSearchIndex(path, want); ok {
+		return idx, nil
+	}
+	return nil, fmt.Errorf("Error opening search index %s", path)
+}
+
+// openCurrentSearchIndex opens the index at path if it exists and was
+// built as described by want.
+func openCurrentSearchIndex(path string, want searchIndexMeta) (*searchIndex, bool) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, false
+	}
+	db, err := OpenDBReadOnly(path)
+	if err != nil {
+		return nil, false
+	}
+	rows, err := db.query("get_search_index_meta", "SELECT key, value FROM meta")
+	if err != nil {
+		db.Close()
+		return nil, false
+	}
+	got := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err == nil {
+			got[key] = value
+		}
+	}
+	rows.Close()
+	for key, value := range want.values() {
+		if got[key] != value {
+			log.WithFields(log.Fields{
+				"action": "search_index",
+				"index":  path,
+				"key":    key,
+				"status": "stale",
+			}).Debug()
+			db.Close()
+			return nil, false
+		}
+	}
+	return &searchIndex{db: db, module: want.module}, true
+}
+
+// searchDocument is the text indexed for a photo.
+type searchDocument struct {
+	id          int
+	caption     string
+	title       string
+	headline    string
+	keywords    string
+	collections string
+	baseName    string
+	folder      string
+}
+
+// getSearchDocuments returns the text to index for every photo in the
+// catalog, keyed by image id.
+func (c *Catalog) getSearchDocuments() (map[int]*searchDocument, error) {
+	const query = `
+SELECT    image.id_local,
+          coalesce(iptc.caption, ''),
+          coalesce(rootfile.baseName, ''),
+          coalesce(rootFolder.absolutePath || folder.pathFromRoot, '')
+FROM      Adobe_images        image
+JOIN      AgLibraryFile       rootFile   ON   rootfile.id_local = image.rootFile
+JOIN      AgLibraryFolder     folder     ON     folder.id_local = rootfile.folder
+JOIN      AgLibraryRootFolder rootFolder ON rootFolder.id_local = folder.rootFolder
+LEFT JOIN AgLibraryIPTC       iptc       ON      image.id_local = iptc.image
+`
+	rows, err := c.db.query("get_search_documents", query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := map[int]*searchDocument{}
+	for rows.Next() {
+		d := &searchDocument{}
+		if err := rows.Scan(&d.id, &d.caption, &d.baseName, &d.folder); err != nil {
+			return nil, err
+		}
+		docs[d.id] = d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if keywords, err := c.getImageKeywords(); err != nil {
+		return nil, err
+	} else {
+		for id, names := range keywords {
+			if d, ok := docs[id]; ok {
+				d.keywords = strings.Join(names, " ")
+			}
+		}
+	}
+	if collections, err := c.getImageCollections(); err != nil {
+		return nil, err
+	} else {
+		for id, names := range collections {
+			if d, ok := docs[id]; ok {
+				d.collections = strings.Join(names, " ")
+			}
+		}
+	}
+	if err := c.addXMPTitles(docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+var (
+	xmpTitleElement    = regexp.MustCompile(`(?s)<dc:title>.*?<rdf:li[^>]*>(.*?)</rdf:li>`)
+	xmpHeadlineAttr    = regexp.MustCompile(`photoshop:Headline="([^"]*)"`)
+	xmpHeadlineElement = regexp.MustCompile(`(?s)<photoshop:Headline>(.*?)</photoshop:Headline>`)
+	xmlUnescaper       = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&#xA;", " ", "&#xD;", " ")
+)
+
+// decodeXMP returns the XMP packet stored in
+// Adobe_AdditionalMetadata.xmp. Older catalogs store it as text; newer
+// ones compress it with zlib, after a 4 byte uncompressed length.
+func decodeXMP(xmp []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(xmp)
+	if len(trimmed) == 0 || trimmed[0] == '<' {
+		return xmp, nil
+	}
+	if len(xmp) > 4 && xmp[4] == 0x78 {
+		xmp = xmp[4:]
+	}
+	r, err := zlib.NewReader(bytes.NewReader(xmp))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// addXMPTitles fills in the titles and headlines of the documents.
+// Lightroom does not store them in a column of their own, only in the
+// XMP metadata of each photo. Photos whose XMP cannot be decoded are
+// indexed without a title or headline, and counted in a warning.
+func (c *Catalog) addXMPTitles(docs map[int]*searchDocument) error {
+	const query = `
+SELECT image,
+       xmp
+FROM   Adobe_AdditionalMetadata
+WHERE  xmp IS NOT NULL
+`
+	rows, err := c.db.query("get_xmp_titles", query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	unreadable := 0
+	for rows.Next() {
+		var id int
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return err
+		}
+		d, ok := docs[id]
+		if !ok {
+			continue
+		}
+		xmp, err := decodeXMP(raw)
+		if err != nil {
+			unreadable++
+			continue
+		}
+		if m := xmpTitleElement.FindSubmatch(xmp); m != nil {
+			d.title = xmlUnescaper.Replace(string(m[1]))
+		}
+		if m := xmpHeadlineAttr.FindSubmatch(xmp); m != nil {
+			d.headline = xmlUnescaper.Replace(string(m[1]))
+		} else if m := xmpHeadlineElement.FindSubmatch(xmp); m != nil {
+			d.headline = xmlUnescaper.Replace(string(m[1]))
+		}
+	}
+	if unreadable > 0 {
+		log.WithFields(log.Fields{
+			"action":  "search_index",
+			"catalog": c.Path(),
+			"photos":  unreadable,
+			"status":  "unreadable_xmp",
+		}).Warn("Unreadable XMP metadata, titles and headlines not indexed")
+	}
+	return rows.Err()
+}
+
+// buildSearchIndex writes a new index of the catalog to path. The
+// index is built in a temporary file which then replaces any previous
+// index, so that an interrupted build never leaves a partial index.
+func (c *Catalog) buildSearchIndex(path string, meta searchIndexMeta) error {
+	start := time.Now()
+	docs, err := c.getSearchDocuments()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	db, err := OpenDB(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := writeSearchIndex(db, docs, meta); err != nil {
+		db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"action":   "search_index",
+		"catalog":  c.Path(),
+		"index":    path,
+		"module":   meta.module,
+		"photos":   len(docs),
+		"duration": time.Since(start),
+		"status":   "built",
+	}).Info("Built search index")
+	return nil
+}
+
+func writeSearchIndex(db *DB, docs map[int]*searchDocument, meta searchIndexMeta) error {
+	columns := make([]string, len(searchColumns))
+	for i, col := range searchColumns {
+		columns[i] = col.name
+	}
+	tokenizer := "tokenize=unicode61"
+	if meta.module == "fts5" {
+		tokenizer = "tokenize='unicode61 remove_diacritics 1'"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE photo_text USING %s (%s, %s)",
+		meta.module, strings.Join(columns, ", "), tokenizer)); err != nil {
+		return err
+	}
+	for key, value := range meta.values() {
+		if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES (?, ?)", key, value); err != nil {
+			return err
+		}
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO photo_text (rowid, %s) VALUES (?%s)",
+		strings.Join(columns, ", "), strings.Repeat(", ?", len(columns))))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, d := range docs {
+		if _, err := insert.Exec(d.id, d.caption, d.title, d.headline,
+			d.keywords, d.collections, d.baseName, d.folder); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// search returns the score of every photo matching query, keyed by
+// image id. Higher scores are better matches.
+func (idx *searchIndex) search(query string) (map[int]float64, error) {
+	if idx.module == "fts5" {
+		return idx.searchFTS5(query)
+	}
+	return idx.searchFTS4(query)
+}
+
+// searchFTS5 ranks matches with the BM25 function built into FTS5,
+// which returns lower values for better matches.
+func (idx *searchIndex) searchFTS5(query string) (map[int]float64, error) {
+	weights := make([]string, len(searchColumns))
+	for i, col := range searchColumns {
+		weights[i] = strconv.FormatFloat(col.weight, 'f', -1, 64)
+	}
+	rows, err := idx.db.DB.Query(fmt.Sprintf(
+		"SELECT rowid, bm25(photo_text, %s) FROM photo_text WHERE photo_text MATCH ?",
+		strings.Join(weights, ", ")), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scores := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var rank float64
+		if err := rows.Scan(&id, &rank); err != nil {
+			return nil, err
+		}
+		scores[id] = -rank
+	}
+	return scores, rows.Err()
+}
+
+// searchFTS4 ranks matches from the hit counts reported by
+// matchinfo(), as FTS4 has no ranking function of its own: each
+// phrase scores the weight of each column it is found in, times the
+// share of all its hits that are in this photo.
+func (idx *searchIndex) searchFTS4(query string) (map[int]float64, error) {
+	rows, err := idx.db.DB.Query(
+		"SELECT rowid, matchinfo(photo_text, 'pcx') FROM photo_text WHERE photo_text MATCH ?", query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scores := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var info []byte
+		if err := rows.Scan(&id, &info); err != nil {
+			return nil, err
+		}
+		scores[id] = fts4Score(info)
+	}
+	return scores, rows.Err()
+}
+
+// fts4Score computes the score of a row from its matchinfo 'pcx'
+// blob of native byte order unsigned integers: the number of phrases
+// p and columns c, followed by 3 values for each phrase and column -
+// hits in this row, hits in all rows and rows with hits.
+func fts4Score(info []byte) float64 {
+	values := make([]uint32, len(info)/4)
+	for i := range values {
+		values[i] = binary.NativeEndian.Uint32(info[i*4:])
+	}
+	if len(values) < 2 {
+		return 0
+	}
+	phrases, columns := int(values[0]), int(values[1])
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(searchColumns); c++ {
+			i := 2 + 3*(p*columns+c)
+			if i+1 >= len(values) {
+				return score
+			}
+			if hits, total := values[i], values[i+1]; hits > 0 {
+				score += searchColumns[c].weight * float64(hits) / float64(total)
+			}
+		}
+	}
+	return score
+}
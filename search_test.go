@@ -0,0 +1,132 @@
+package luminosity
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+// matchinfo returns a matchinfo 'pcx' blob for the given number of
+// phrases and columns, followed by the hit counts - hits in this
+// row, hits in all rows and rows with hits, for each phrase and
+// column.
+func matchinfo(phrases, columns int, hits ...uint32) []byte {
+	values := append([]uint32{uint32(phrases), uint32(columns)}, hits...)
+	blob := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.NativeEndian.PutUint32(blob[4*i:], v)
+	}
+	return blob
+}
+
+// noHits is the 'cx' triple of a column without matches.
+var noHits = []uint32{0, 0, 0}
+
+func columnHits(triples ...[]uint32) []uint32 {
+	var hits []uint32
+	for _, t := range triples {
+		hits = append(hits, t...)
+	}
+	return hits
+}
+
+func TestFTS4Score(t *testing.T) {
+	columns := len(searchColumns)
+	tests := []struct {
+		name string
+		info []byte
+		want float64
+	}{
+		{
+			name: "caption hit",
+			info: matchinfo(1, columns, columnHits(
+				[]uint32{1, 4, 4}, noHits, noHits, noHits, noHits, noHits, noHits)...),
+			want: 1 * 1.0 / 4,
+		},
+		{
+			name: "keyword hit weighs more than caption",
+			info: matchinfo(1, columns, columnHits(
+				noHits, noHits, noHits, []uint32{1, 4, 4}, noHits, noHits, noHits)...),
+			want: 2 * 1.0 / 4,
+		},
+		{
+			name: "two columns",
+			info: matchinfo(1, columns, columnHits(
+				[]uint32{2, 4, 2}, []uint32{1, 1, 1}, noHits, noHits, noHits, noHits, []uint32{1, 10, 10})...),
+			want: 1*2.0/4 + 2*1.0/1 + 0.5*1.0/10,
+		},
+		{
+			name: "two phrases",
+			info: matchinfo(2, columns, columnHits(
+				[]uint32{1, 2, 2}, noHits, noHits, noHits, noHits, noHits, noHits,
+				noHits, noHits, noHits, noHits, []uint32{1, 5, 5}, noHits, noHits)...),
+			want: 1*1.0/2 + 1*1.0/5,
+		},
+		{
+			name: "no hits",
+			info: matchinfo(1, columns, columnHits(
+				noHits, noHits, noHits, noHits, noHits, noHits, noHits)...),
+			want: 0,
+		},
+		{
+			name: "truncated",
+			info: matchinfo(2, columns, []uint32{1, 2, 2}...),
+			want: 1 * 1.0 / 2,
+		},
+		{
+			name: "empty",
+			info: nil,
+			want: 0,
+		},
+	}
+	for _, test := range tests {
+		if got := fts4Score(test.info); got != test.want {
+			t.Errorf("%s: fts4Score() = %g, want %g", test.name, got, test.want)
+		}
+	}
+}
+
+// compressedXMP compresses an XMP packet the way newer catalogs store
+// it, after its uncompressed length.
+func compressedXMP(xmp string, prefixed bool) []byte {
+	var b bytes.Buffer
+	if prefixed {
+		binary.Write(&b, binary.BigEndian, uint32(len(xmp)))
+	}
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(xmp))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecodeXMP(t *testing.T) {
+	const xmp = `<x:xmpmeta><dc:title><rdf:Alt><rdf:li xml:lang="x-default">Beach</rdf:li></rdf:Alt></dc:title></x:xmpmeta>`
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{"text", []byte(xmp), xmp, false},
+		{"leading whitespace", []byte("\n " + xmp), "\n " + xmp, false},
+		{"empty", []byte{}, "", false},
+		{"compressed with length", compressedXMP(xmp, true), xmp, false},
+		{"compressed", compressedXMP(xmp, false), xmp, false},
+		{"garbage", []byte{0, 0, 1, 0, 0x12, 0x34}, "", true},
+	}
+	for _, test := range tests {
+		got, err := decodeXMP(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: decodeXMP() = %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: decodeXMP(): unexpected error %s", test.name, err)
+		} else if string(got) != test.want {
+			t.Errorf("%s: decodeXMP() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package luminosity
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SequenceType classifies a sequence of photos shot in quick
+// succession.
+type SequenceType string
+
+const (
+	// SequenceBurst is a series of frames shot with the same settings
+	// at a high frame rate.
+	SequenceBurst SequenceType = "burst"
+
+	// SequenceBracket is an exposure bracket - frames shot at the
+	// same aperture with varying shutter speed or exposure value,
+	// typically for HDR merging.
+	SequenceBracket SequenceType = "bracket"
+
+	// SequenceFocusStack is a series of frames shot with the same
+	// settings at a slower cadence than a burst. Lightroom does not
+	// record the focus distance, so focus stacks are told apart from
+	// bursts by the interval between frames only.
+	SequenceFocusStack SequenceType = "focus_stack"
+)
+
+const (
+	// DefaultSequenceWindow is the longest interval between two
+	// consecutive frames of a sequence.
+	DefaultSequenceWindow = time.Second
+
+	// DefaultBurstInterval is the longest mean interval between the
+	// frames of a burst. Sequences of identical settings shot more
+	// slowly are classified as focus stacks.
+	DefaultBurstInterval = 500 * time.Millisecond
+)
+
+// bracketThreshold is the smallest variation in APEX exposure value,
+// a third of a stop, which makes a sequence an exposure bracket.
+const bracketThreshold = 0.3
+
+// apertureTolerance is the largest variation in APEX aperture value
+// within an exposure bracket.
+const apertureTolerance = 0.1
+
+// SequenceOptions controls the detection of sequences. Zero values
+// select the defaults.
+type SequenceOptions struct {
+	Window        time.Duration
+	BurstInterval time.Duration
+}
+
+func (o SequenceOptions) window() time.Duration {
+	if o.Window > 0 {
+		return o.Window
+	}
+	return DefaultSequenceWindow
+}
+
+func (o SequenceOptions) burstInterval() time.Duration {
+	if o.BurstInterval > 0 {
+		return o.BurstInterval
+	}
+	return DefaultBurstInterval
+}
+
+// Sequence is a series of photos shot by the same camera body with no
+// more than the sequence window between consecutive frames.
+type Sequence struct {
+	Id     int          `json:"id"`
+	Type   SequenceType `json:"type"`
+	Camera string       `json:"camera"`
+	Serial string       `json:"serial"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Frames int          `json:"frames"`
+
+	Photos []*PhotoRecord `json:"-"`
+}
+
+// classify determines the type of the sequence from the exposure
+// settings of its frames and the interval between them.
+func (s *Sequence) classify(duration time.Duration, burstInterval time.Duration) SequenceType {
+	minAperture, maxAperture := math.Inf(1), math.Inf(-1)
+	minShutter, maxShutter := math.Inf(1), math.Inf(-1)
+	minEV, maxEV := math.Inf(1), math.Inf(-1)
+	exposures := 0
+	for _, p := range s.Photos {
+		if p.FNumber == "" || p.ExposureTime == "" {
+			continue
+		}
+		exposures++
+		ev := ExposureValue(p.Aperture, p.ShutterSpeed)
+		minAperture, maxAperture = math.Min(minAperture, p.Aperture), math.Max(maxAperture, p.Aperture)
+		minShutter, maxShutter = math.Min(minShutter, p.ShutterSpeed), math.Max(maxShutter, p.ShutterSpeed)
+		minEV, maxEV = math.Min(minEV, ev), math.Max(maxEV, ev)
+	}
+	if exposures == len(s.Photos) && maxAperture-minAperture <= apertureTolerance &&
+		(maxShutter-minShutter >= bracketThreshold || maxEV-minEV >= bracketThreshold) {
+		return SequenceBracket
+	}
+	if duration/time.Duration(len(s.Photos)-1) > burstInterval {
+		return SequenceFocusStack
+	}
+	return SequenceBurst
+}
+
+type SequenceList []*Sequence
+
+// DetectSequences finds the sequences of photos shot by the same
+// camera body (model and serial number) within the sequence window of
+// each other, and classifies them. The SequenceId of every photo in a
+// sequence is set to the id of the sequence; other photos are left
+// with a SequenceId of 0. Videos are ignored.
+func DetectSequences(photos []*PhotoRecord, basis TimeBasis, options SequenceOptions) SequenceList {
+	type timedPhoto struct {
+		key   time.Time
+		photo *PhotoRecord
+	}
+	bodies := map[string][]timedPhoto{}
+	var keys []string
+	for _, p := range photos {
+		p.SequenceId = 0
+		if p.IsVideo || p.CaptureTime.IsZero() {
+			continue
+		}
+		key := p.Camera.String + "\x00" + p.CameraSerial.String
+		if _, ok := bodies[key]; !ok {
+			keys = append(keys, key)
+		}
+		bodies[key] = append(bodies[key], timedPhoto{basis.orderingKey(basis.In(p.CaptureTime)), p})
+	}
+	sort.Strings(keys)
+
+	window := options.window()
+	var sequences SequenceList
+	for _, key := range keys {
+		timed := bodies[key]
+		sort.SliceStable(timed, func(i, j int) bool {
+			return timed[i].key.Before(timed[j].key)
+		})
+		for start := 0; start < len(timed); {
+			end := start + 1
+			for end < len(timed) && timed[end].key.Sub(timed[end-1].key) <= window {
+				end++
+			}
+			if end-start > 1 {
+				first, last := timed[start], timed[end-1]
+				s := &Sequence{
+					Camera: first.photo.Camera.String,
+					Serial: first.photo.CameraSerial.String,
+					Start:  first.photo.CaptureTime,
+					End:    last.photo.CaptureTime,
+					Frames: end - start,
+				}
+				for _, tp := range timed[start:end] {
+					s.Photos = append(s.Photos, tp.photo)
+				}
+				s.Type = s.classify(last.key.Sub(first.key), options.burstInterval())
+				sequences = append(sequences, s)
+			}
+			start = end
+		}
+	}
+
+	// Number the sequences of all bodies in capture order
+	sort.SliceStable(sequences, func(i, j int) bool {
+		return basis.orderingKey(basis.In(sequences[i].Start)).Before(
+			basis.orderingKey(basis.In(sequences[j].Start)))
+	})
+	for i, s := range sequences {
+		s.Id = i + 1
+		for _, p := range s.Photos {
+			p.SequenceId = s.Id
+		}
+	}
+	return sequences
+}
+
+// SequenceStats summarizes the sequences detected in a catalog.
+type SequenceStats struct {
+	// Count is the number of sequences, and Frames the number of
+	// photos which are part of one.
+	Count  int64 `json:"count"`
+	Frames int64 `json:"frames"`
+
+	// ByType counts sequences by SequenceType.
+	ByType DistributionList `json:"by_type"`
+	// ByFrames counts sequences by their number of frames.
+	ByFrames DistributionList `json:"by_frames"`
+}
+
+func newSequenceStats() *SequenceStats {
+	return &SequenceStats{
+		ByType:   DistributionList{},
+		ByFrames: DistributionList{},
+	}
+}
+
+// Merge adds the counts of other into s.
+func (s *SequenceStats) Merge(other *SequenceStats) {
+	if other == nil {
+		return
+	}
+	s.Count += other.Count
+	s.Frames += other.Frames
+	s.ByType = s.ByType.Merge(other.ByType)
+	s.ByFrames = s.ByFrames.Merge(other.ByFrames)
+	sort.Sort(byId(s.ByFrames))
+}
+
+// GetSequenceStats detects the sequences of the photos in the
+// catalog, according to StatsOptions.Sequences, and returns their
+// counts. The sequence id of each photo record is set as a side
+// effect.
+func (c *Catalog) GetSequenceStats() (*SequenceStats, error) {
+	photos, err := c.statsPhotos()
+	if err != nil {
+		return nil, err
+	}
+	stats := newSequenceStats()
+	types := DistributionMap{}
+	frames := DistributionMap{}
+	for _, s := range DetectSequences(photos, c.StatsOptions.TimeBasis, c.StatsOptions.Sequences) {
+		stats.Count++
+		stats.Frames += int64(s.Frames)
+		types.add(0, string(s.Type), 1)
+		frames.add(int64(s.Frames), strconv.Itoa(s.Frames), 1)
+	}
+	stats.ByType = types.ToList()
+	sort.Sort(stats.ByType)
+	stats.ByFrames = frames.ToList()
+	sort.Sort(byId(stats.ByFrames))
+	return stats, nil
+}
+
+// GetSequenceIds detects the sequences of the photos in the catalog,
+// according to StatsOptions.Sequences, and returns the sequence id of
+// every photo which is part of one, keyed by image id. Only the
+// fields needed to detect sequences are kept in memory, so that the
+// ids can be added to photo records which are streamed.
+func (c *Catalog) GetSequenceIds() (map[int]int, error) {
+	var photos []*PhotoRecord
+	err := c.ForEachPhoto(func(p *PhotoRecord) error {
+		photos = append(photos, &PhotoRecord{
+			Id:           p.Id,
+			Camera:       p.Camera,
+			CameraSerial: p.CameraSerial,
+			CaptureTime:  p.CaptureTime,
+			IsVideo:      p.IsVideo,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := map[int]int{}
+	for _, s := range DetectSequences(photos, c.StatsOptions.TimeBasis, c.StatsOptions.Sequences) {
+		for _, p := range s.Photos {
+			ids[p.Id] = s.Id
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,357 @@
+package luminosity
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultSessionGap is the longest interval between two
+	// consecutive photos of the same shooting session.
+	DefaultSessionGap = 2 * time.Hour
+
+	// DefaultTripGap is the longest interval between two consecutive
+	// sessions of the same trip.
+	DefaultTripGap = 36 * time.Hour
+
+	// DefaultTripDistance is the greatest distance, in kilometers,
+	// between the locations of two consecutive sessions of the same
+	// trip.
+	DefaultTripDistance = 250.0
+)
+
+// SessionOptions controls how photos are clustered into sessions and
+// trips. Zero values select the defaults.
+type SessionOptions struct {
+	// SessionGap is the longest interval between two consecutive
+	// photos of the same session.
+	SessionGap time.Duration
+
+	// TripGap is the longest interval between the end of a session
+	// and the start of the next for both to belong to the same trip.
+	TripGap time.Duration
+
+	// TripDistance is the greatest distance in kilometers between the
+	// centers of two consecutive sessions of the same trip. It is
+	// only checked when both sessions have GPS coordinates.
+	TripDistance float64
+}
+
+func (o SessionOptions) sessionGap() time.Duration {
+	if o.SessionGap > 0 {
+		return o.SessionGap
+	}
+	return DefaultSessionGap
+}
+
+func (o SessionOptions) tripGap() time.Duration {
+	if o.TripGap > 0 {
+		return o.TripGap
+	}
+	return DefaultTripGap
+}
+
+func (o SessionOptions) tripDistance() float64 {
+	if o.TripDistance > 0 {
+		return o.TripDistance
+	}
+	return DefaultTripDistance
+}
+
+// BoundingBox is the smallest latitude/longitude rectangle containing
+// a set of GPS coordinates.
+type BoundingBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+func (b *BoundingBox) extend(lat, lon float64) {
+	b.MinLat = math.Min(b.MinLat, lat)
+	b.MinLon = math.Min(b.MinLon, lon)
+	b.MaxLat = math.Max(b.MaxLat, lat)
+	b.MaxLon = math.Max(b.MaxLon, lon)
+}
+
+// Union returns the bounding box containing both b and other, either
+// of which may be nil.
+func (b *BoundingBox) Union(other *BoundingBox) *BoundingBox {
+	if b == nil && other == nil {
+		return nil
+	}
+	if b == nil {
+		c := *other
+		return &c
+	}
+	c := *b
+	if other != nil {
+		c.extend(other.MinLat, other.MinLon)
+		c.extend(other.MaxLat, other.MaxLon)
+	}
+	return &c
+}
+
+// Center returns the coordinates of the center of the box.
+func (b *BoundingBox) Center() (float64, float64) {
+	return (b.MinLat + b.MaxLat) / 2, (b.MinLon + b.MaxLon) / 2
+}
+
+// earthRadius is the mean radius of the Earth in kilometers.
+const earthRadius = 6371.0
+
+// Distance returns the great-circle distance in kilometers between
+// two points given in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// CaptureSummary describes a group of photos shot together - their
+// date range, count, the cameras and lenses used, and the area
+// covered.
+type CaptureSummary struct {
+	Start   time.Time        `json:"start"`
+	End     time.Time        `json:"end"`
+	Count   int64            `json:"count"`
+	Cameras DistributionList `json:"cameras"`
+	Lenses  DistributionList `json:"lenses"`
+
+	// Bounds is only set when some of the photos have GPS
+	// coordinates.
+	Bounds *BoundingBox `json:"bounds,omitempty"`
+}
+
+// Duration returns the time between the first and last photo.
+func (s *CaptureSummary) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// merge adds other to s, keeping the earliest start and latest end
+// as ordered by the time basis.
+func (s *CaptureSummary) merge(basis TimeBasis, other *CaptureSummary) {
+	if s.Count == 0 || basis.orderingKey(other.Start).Before(basis.orderingKey(s.Start)) {
+		s.Start = other.Start
+	}
+	if s.Count == 0 || basis.orderingKey(other.End).After(basis.orderingKey(s.End)) {
+		s.End = other.End
+	}
+	s.Count += other.Count
+	s.Cameras = s.Cameras.Merge(other.Cameras)
+	s.Lenses = s.Lenses.Merge(other.Lenses)
+	s.Bounds = s.Bounds.Union(other.Bounds)
+}
+
+// Session is a set of photos shot with no gap between consecutive
+// photos longer than the session gap.
+type Session struct {
+	Id int `json:"id"`
+	CaptureSummary
+
+	// Photos holds the photos of the session, when it was detected
+	// from photo records. It is not preserved by merging.
+	Photos []*PhotoRecord `json:"-"`
+}
+
+type SessionList []*Session
+
+func (l SessionList) Len() int           { return len(l) }
+func (l SessionList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l SessionList) Less(i, j int) bool { return l[i].Start.Before(l[j].Start) }
+
+// Trip is a set of consecutive sessions spanning several days, with
+// no gap between sessions longer than the trip gap.
+type Trip struct {
+	Id int `json:"id"`
+	CaptureSummary
+
+	// Sessions holds the ids of the sessions making up the trip.
+	Sessions []int `json:"sessions"`
+}
+
+type TripList []*Trip
+
+// DetectSessions clusters photos into sessions, starting a new
+// session whenever the interval between two consecutive photos
+// exceeds the session gap. Photos without a capture time are
+// ignored. Capture times are interpreted according to basis.
+func DetectSessions(photos []*PhotoRecord, basis TimeBasis, options SessionOptions) SessionList {
+	type timedPhoto struct {
+		t     time.Time
+		key   time.Time
+		photo *PhotoRecord
+	}
+	var timed []timedPhoto
+	for _, p := range photos {
+		if !p.CaptureTime.IsZero() {
+			t := basis.In(p.CaptureTime)
+			timed = append(timed, timedPhoto{t, basis.orderingKey(t), p})
+		}
+	}
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].key.Before(timed[j].key)
+	})
+
+	gap := options.sessionGap()
+	var sessions SessionList
+	var current *Session
+	var last time.Time
+	var cameras, lenses DistributionMap
+	finish := func() {
+		if current != nil {
+			current.Cameras = cameras.ToList()
+			current.Lenses = lenses.ToList()
+			sort.Sort(current.Cameras)
+			sort.Sort(current.Lenses)
+		}
+	}
+	for _, tp := range timed {
+		if current == nil || tp.key.Sub(last) > gap {
+			finish()
+			current = &Session{
+				Id:             len(sessions) + 1,
+				CaptureSummary: CaptureSummary{Start: tp.t},
+			}
+			cameras = DistributionMap{}
+			lenses = DistributionMap{}
+			sessions = append(sessions, current)
+		}
+		p := tp.photo
+		current.End = tp.t
+		last = tp.key
+		current.Count++
+		current.Photos = append(current.Photos, p)
+		if p.Camera.Valid {
+			cameras.add(0, p.Camera.String, 1)
+		}
+		if p.Lens.Valid {
+			lenses.add(0, p.Lens.String, 1)
+		}
+		if p.HasGPS && p.Latitude.Valid && p.Longitude.Valid {
+			lat, lon := p.Latitude.Float64, p.Longitude.Float64
+			if current.Bounds == nil {
+				current.Bounds = &BoundingBox{lat, lon, lat, lon}
+			} else {
+				current.Bounds.extend(lat, lon)
+			}
+		}
+	}
+	finish()
+	return sessions
+}
+
+// DetectTrips groups consecutive sessions into trips. Two sessions
+// belong to the same trip when the interval between them does not
+// exceed the trip gap and, if both have GPS coordinates, their
+// centers are no further apart than the trip distance. Only groups
+// of sessions spanning more than one calendar day are returned.
+// Intervals are measured on the time basis the sessions were
+// detected with.
+func DetectTrips(sessions SessionList, basis TimeBasis, options SessionOptions) TripList {
+	gap := options.tripGap()
+	distance := options.tripDistance()
+
+	var trips TripList
+	var current *Trip
+	var last *Session
+	finish := func() {
+		if current != nil && current.Start.Format(DayFormat) != current.End.Format(DayFormat) {
+			current.Id = len(trips) + 1
+			trips = append(trips, current)
+		}
+	}
+	for _, s := range sessions {
+		joined := last != nil && basis.orderingKey(s.Start).Sub(basis.orderingKey(last.End)) <= gap
+		if joined && last.Bounds != nil && s.Bounds != nil {
+			lat1, lon1 := last.Bounds.Center()
+			lat2, lon2 := s.Bounds.Center()
+			joined = Distance(lat1, lon1, lat2, lon2) <= distance
+		}
+		if !joined {
+			finish()
+			current = &Trip{}
+		}
+		current.merge(basis, &s.CaptureSummary)
+		current.Sessions = append(current.Sessions, s.Id)
+		last = s
+	}
+	finish()
+	return trips
+}
+
+// SessionStats holds the shooting sessions and trips detected in a
+// catalog.
+type SessionStats struct {
+	Sessions SessionList `json:"sessions"`
+	Trips    TripList    `json:"trips"`
+}
+
+func newSessionStats() *SessionStats {
+	return &SessionStats{
+		Sessions: SessionList{},
+		Trips:    TripList{},
+	}
+}
+
+// Merge adds the sessions of other to s. Sessions and trips must be
+// regrouped with Regroup afterwards, as sessions from different
+// catalogs may overlap.
+func (s *SessionStats) Merge(other *SessionStats) {
+	if other == nil {
+		return
+	}
+	s.Sessions = append(s.Sessions, other.Sessions...)
+}
+
+// Regroup combines overlapping sessions, or sessions separated by
+// less than the session gap, and detects trips again over the
+// combined sessions. Sessions and trips are renumbered. Sessions are
+// ordered and compared on the same time basis as DetectSessions.
+func (s *SessionStats) Regroup(basis TimeBasis, options SessionOptions) {
+	sort.SliceStable(s.Sessions, func(i, j int) bool {
+		return basis.orderingKey(s.Sessions[i].Start).Before(basis.orderingKey(s.Sessions[j].Start))
+	})
+	gap := options.sessionGap()
+	var sessions SessionList
+	for _, session := range s.Sessions {
+		if n := len(sessions); n > 0 && basis.orderingKey(session.Start).Sub(basis.orderingKey(sessions[n-1].End)) <= gap {
+			sessions[n-1].merge(basis, &session.CaptureSummary)
+			sessions[n-1].Photos = append(sessions[n-1].Photos, session.Photos...)
+			continue
+		}
+		c := *session
+		c.Id = len(sessions) + 1
+		sessions = append(sessions, &c)
+	}
+	s.Sessions = sessions
+	if s.Sessions == nil {
+		s.Sessions = SessionList{}
+	}
+	s.Trips = DetectTrips(s.Sessions, basis, options)
+	if s.Trips == nil {
+		s.Trips = TripList{}
+	}
+}
+
+// GetSessions clusters the photos of the catalog into shooting
+// sessions and trips, according to StatsOptions.Sessions and
+// StatsOptions.TimeBasis.
+func (c *Catalog) GetSessions() (*SessionStats, error) {
+	photos, err := c.statsPhotos()
+	if err != nil {
+		return nil, err
+	}
+	s := newSessionStats()
+	if sessions := DetectSessions(photos, c.StatsOptions.TimeBasis, c.StatsOptions.Sessions); sessions != nil {
+		s.Sessions = sessions
+	}
+	if trips := DetectTrips(s.Sessions, c.StatsOptions.TimeBasis, c.StatsOptions.Sessions); trips != nil {
+		s.Trips = trips
+	}
+	return s, nil
+}
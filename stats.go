@@ -1,7 +1,7 @@
 package luminosity
 
 import (
-	"sort"
+	"encoding/json"
 )
 
 type Stats struct {
@@ -13,6 +13,109 @@ type Stats struct {
 	ByExposureTime DistributionList `json:"by_exposure_time"`
 	ByEditCount    DistributionList `json:"by_edit_count"`
 	ByKeyword      DistributionList `json:"by_keyword"`
+
+	ByISO                  DistributionList `json:"by_iso"`
+	ByExposureValue        DistributionList `json:"by_exposure_value"`
+	ByLightValue           DistributionList `json:"by_light_value"`
+	ByApertureExposureTime DistributionList `json:"by_aperture_exposure_time"`
+	ByISOFocalLength       DistributionList `json:"by_iso_focal_length"`
+
+	// ByEquivalentFocalLength counts photos by 35mm-equivalent focal
+	// length. Photos from cameras with an unknown crop factor are
+	// excluded, and counted by camera in UnknownCropFactorCameras.
+	ByEquivalentFocalLength  DistributionList `json:"by_equivalent_focal_length"`
+	UnknownCropFactorCameras DistributionList `json:"unknown_crop_factor_cameras"`
+
+	ByRating     DistributionList `json:"by_rating"`
+	ByPick       DistributionList `json:"by_pick"`
+	ByColorLabel DistributionList `json:"by_color_label"`
+
+	ByFileFormat  DistributionList `json:"by_file_format"`
+	ByMegapixels  DistributionList `json:"by_megapixels"`
+	ByOrientation DistributionList `json:"by_orientation"`
+	ByAspectRatio DistributionList `json:"by_aspect_ratio"`
+
+	// Sequences counts the bursts, exposure brackets and focus
+	// stacks.
+	Sequences *SequenceStats `json:"sequences"`
+
+	// Videos counts the videos in the catalog, and their total
+	// duration.
+	Videos *VideoStats `json:"videos"`
+
+	// Sessions holds the shooting sessions and multi-day trips
+	// detected from capture times and locations.
+	Sessions *SessionStats `json:"sessions"`
+
+	// KeeperRates tracks the fraction of photos which are rated or
+	// picked, overall and by camera, lens and year.
+	KeeperRates *KeeperRates `json:"keeper_rates"`
+
+	// Distributions holds the distributions computed by providers
+	// registered with RegisterStatsProvider, keyed by name.
+	Distributions map[string]DistributionList `json:"distributions,omitempty"`
+
+	// ByTime holds the distributions for each time bucket requested
+	// in StatsOptions.TimeBuckets, keyed by bucket name.
+	ByTime map[string]DistributionList `json:"by_time,omitempty"`
+
+	// Histograms holds binned versions of the distributions, as
+	// computed by StandardHistograms(). They are derived data and
+	// are not merged.
+	Histograms map[string]DistributionList `json:"histograms,omitempty"`
+}
+
+// StatsOptions controls how GetStats computes a catalog's
+// distributions.
+type StatsOptions struct {
+	// TimeBasis selects whether date based distributions bucket
+	// photos by their local wall-clock capture time (the default) or
+	// by UTC.
+	TimeBasis TimeBasis
+
+	// TimeBuckets lists the time buckets for which distributions
+	// are computed into Stats.ByTime.
+	TimeBuckets []TimeBucket
+
+	// FillEmptyBuckets adds zero count entries for dates and time
+	// buckets with no photos between the first and last capture.
+	FillEmptyBuckets bool
+
+	// CropFactors supplements DefaultCropFactors when computing
+	// 35mm-equivalent focal lengths.
+	CropFactors CropFactorTable
+
+	// ExcludeVideos leaves videos out of every distribution, and of
+	// the photo records. Videos are always counted in Stats.Videos.
+	ExcludeVideos bool
+
+	// Window restricts the distributions, photo records, sessions and
+	// sequences to photos captured within a date range.
+	Window DateWindow
+
+	// Sessions controls the detection of shooting sessions and
+	// trips.
+	Sessions SessionOptions
+
+	// Sequences controls the detection of bursts, brackets and focus
+	// stacks.
+	Sequences SequenceOptions
+
+	// Distributions lists the names of the registered StatsProviders
+	// to run. If empty, every provider runs, except for those listed
+	// in ExcludeDistributions. Distributions which are not computed
+	// are left empty.
+	Distributions        []string
+	ExcludeDistributions []string
+
+	// AttributeCatalogs makes Catalog.Merge record the catalog each
+	// distribution count came from, in DistributionEntry.ByCatalog.
+	AttributeCatalogs bool
+
+	// Names canonicalizes lens and camera names in the lens and
+	// camera lists, distributions and photo records. If nil, only
+	// the built-in normalization rules are applied.
+	Names *NameNormalizer
 }
 
 func newStats() *Stats {
@@ -25,80 +128,150 @@ func newStats() *Stats {
 		ByExposureTime: DistributionList{},
 		ByEditCount:    DistributionList{},
 		ByKeyword:      DistributionList{},
+
+		ByISO:                  DistributionList{},
+		ByExposureValue:        DistributionList{},
+		ByLightValue:           DistributionList{},
+		ByApertureExposureTime: DistributionList{},
+		ByISOFocalLength:       DistributionList{},
+
+		ByEquivalentFocalLength:  DistributionList{},
+		UnknownCropFactorCameras: DistributionList{},
+
+		ByRating:     DistributionList{},
+		ByPick:       DistributionList{},
+		ByColorLabel: DistributionList{},
+		KeeperRates:  newKeeperRates(),
+		Videos:       newVideoStats(),
+		Sessions:     newSessionStats(),
+		Sequences:    newSequenceStats(),
+
+		ByFileFormat:  DistributionList{},
+		ByMegapixels:  DistributionList{},
+		ByOrientation: DistributionList{},
+		ByAspectRatio: DistributionList{},
 	}
 }
 
+// UnmarshalJSON decodes stats previously written as JSON. Fields
+// missing from the JSON, such as those added in later versions, are
+// initialized empty so that the stats can be merged.
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	type stats Stats
+	decoded := (*stats)(newStats())
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+	*s = Stats(*decoded)
+	if s.KeeperRates == nil {
+		s.KeeperRates = newKeeperRates()
+	} else if s.KeeperRates.Overall == nil {
+		s.KeeperRates.Overall = &KeeperRate{Label: "all"}
+	}
+	if s.Videos == nil {
+		s.Videos = newVideoStats()
+	}
+	if s.Sessions == nil {
+		s.Sessions = newSessionStats()
+	}
+	if s.Sequences == nil {
+		s.Sequences = newSequenceStats()
+	}
+	return nil
+}
+
+// Merge adds the stats of other into s, merging the results of every
+// registered StatsProvider. Distributions in Stats.Distributions whose
+// provider is not registered, such as those read from JSON, are
+// merged as well.
 func (s *Stats) Merge(other *Stats) {
-	s.ByDate = s.ByDate.Merge(other.ByDate)
-	s.ByCamera = s.ByCamera.Merge(other.ByCamera)
-	s.ByLens = s.ByLens.Merge(other.ByLens)
-	s.ByFocalLength = s.ByFocalLength.Merge(other.ByFocalLength)
-	s.ByAperture = s.ByAperture.Merge(other.ByAperture)
-	s.ByExposureTime = s.ByExposureTime.Merge(other.ByExposureTime)
-	s.ByEditCount = s.ByEditCount.Merge(other.ByEditCount)
-	s.ByKeyword = s.ByKeyword.Merge(other.ByKeyword)
-
-	sort.Sort(ByDate(s.ByDate))
+	for _, p := range statsProviders {
+		p.Merge(s, other)
+	}
+	for name, dist := range other.Distributions {
+		if FindStatsProvider(name) != nil {
+			continue
+		}
+		if s.Distributions == nil {
+			s.Distributions = map[string]DistributionList{}
+		}
+		s.Distributions[name] = s.Distributions[name].Merge(dist)
+	}
 }
 
-func (c *Catalog) GetStats() (*Stats, error) {
-	if c.Stats != nil {
-		return c.Stats, nil
+// FillEmptyBuckets adds zero count entries to the date and time
+// bucket distributions for every bucket with no photos, between the
+// first and last capture. This is typically needed after merging, as
+// catalogs covering disjoint periods leave gaps between them.
+func (s *Stats) FillEmptyBuckets() {
+	s.ByDate = TimeBucketDay.Fill(s.ByDate)
+	for name, dist := range s.ByTime {
+		if bucket, err := ParseTimeBucket(name); err == nil {
+			s.ByTime[name] = bucket.Fill(dist)
+		}
 	}
+}
 
-	s := newStats()
+// statsRun holds the data loaded from the catalog for a single
+// GetStats run, which several providers aggregate over, so that it is
+// only read once and released when the stats are complete.
+type statsRun struct {
+	photos []*PhotoRecord
 
-	if c.db == nil {
-		c.Stats = s
-		return s, nil
-	}
+	// exposures holds the result of getExposureSettings, from which
+	// the EV, LV, aperture and exposure time, and ISO and focal
+	// length distributions are all derived.
+	exposures       []exposureSettings
+	exposuresLoaded bool
 
-	if d, err := c.GetPhotoCountsByDate(); err != nil {
-		return nil, err
-	} else {
-		s.ByDate = d
-	}
+	// frameSizes holds the result of getFrameSizes, from which the
+	// megapixel, orientation and aspect ratio distributions are
+	// derived.
+	frameSizes       []frameSize
+	frameSizesLoaded bool
+}
 
-	if d, err := c.GetCameraDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByCamera = d
+// statsPhotos returns the photo records of the catalog. While GetStats
+// is running they are loaded only once and shared between providers.
+func (c *Catalog) statsPhotos() ([]*PhotoRecord, error) {
+	if c.run == nil {
+		return c.GetPhotos()
 	}
-
-	if d, err := c.GetLensDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByLens = d
+	if c.run.photos == nil {
+		photos, err := c.GetPhotos()
+		if err != nil {
+			return nil, err
+		}
+		c.run.photos = photos
 	}
+	return c.run.photos, nil
+}
 
-	if d, err := c.GetFocalLengthDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByFocalLength = d
+// GetStats computes the stats of the catalog by running the
+// registered StatsProviders selected by StatsOptions. The stats are
+// computed once, and cached in the catalog.
+func (c *Catalog) GetStats() (*Stats, error) {
+	if c.Stats != nil {
+		return c.Stats, nil
 	}
 
-	if d, err := c.GetApertureDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByAperture = d
-	}
+	s := newStats()
 
-	if d, err := c.GetExposureTimeDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByExposureTime = d
+	if c.db == nil {
+		c.Stats = s
+		return s, nil
 	}
 
-	if d, err := c.GetEditCountDistribution(); err != nil {
+	providers, err := c.StatsOptions.selectedProviders()
+	if err != nil {
 		return nil, err
-	} else {
-		s.ByEditCount = d
 	}
-
-	if d, err := c.GetKeywordDistribution(); err != nil {
-		return nil, err
-	} else {
-		s.ByKeyword = d
+	c.run = &statsRun{}
+	defer func() { c.run = nil }()
+	for _, p := range providers {
+		if err := p.Compute(c, s); err != nil {
+			return nil, err
+		}
 	}
 
 	c.Stats = s
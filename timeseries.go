@@ -0,0 +1,325 @@
+package luminosity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeBucket identifies a granularity for grouping photos by capture
+// time. Calendar buckets (hour, day, week, month, year) form a
+// continuous series between the earliest and latest capture, while
+// cyclic buckets (weekday, hour of day and the weekday × hour
+// heatmap) repeat and are ordered by their entry Id.
+type TimeBucket int
+
+const (
+	TimeBucketHour TimeBucket = iota
+	TimeBucketDay
+	TimeBucketWeek
+	TimeBucketMonth
+	TimeBucketYear
+	TimeBucketWeekday
+	TimeBucketHourOfDay
+	TimeBucketWeekdayHour
+)
+
+const (
+	HourFormat  = "2006-01-02T15"
+	MonthFormat = "2006-01"
+	YearFormat  = "2006"
+)
+
+// AllTimeBuckets lists every supported time bucket.
+var AllTimeBuckets = []TimeBucket{
+	TimeBucketHour,
+	TimeBucketDay,
+	TimeBucketWeek,
+	TimeBucketMonth,
+	TimeBucketYear,
+	TimeBucketWeekday,
+	TimeBucketHourOfDay,
+	TimeBucketWeekdayHour,
+}
+
+// cycleStart is an arbitrary Monday at midnight, used to generate
+// the entries of the cyclic buckets.
+var cycleStart = time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func (b TimeBucket) String() string {
+	switch b {
+	case TimeBucketHour:
+		return "hour"
+	case TimeBucketDay:
+		return "day"
+	case TimeBucketWeek:
+		return "week"
+	case TimeBucketMonth:
+		return "month"
+	case TimeBucketYear:
+		return "year"
+	case TimeBucketWeekday:
+		return "weekday"
+	case TimeBucketHourOfDay:
+		return "hour_of_day"
+	case TimeBucketWeekdayHour:
+		return "weekday_hour"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTimeBucket converts the name of a time bucket, as returned by
+// String(), to a TimeBucket value.
+func ParseTimeBucket(s string) (TimeBucket, error) {
+	for _, b := range AllTimeBuckets {
+		if b.String() == strings.ToLower(s) {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown time bucket %q", s)
+}
+
+// IsCyclic returns true for buckets which repeat over time, such as
+// the day of the week, rather than forming a calendar series.
+func (b TimeBucket) IsCyclic() bool {
+	return b == TimeBucketWeekday || b == TimeBucketHourOfDay || b == TimeBucketWeekdayHour
+}
+
+// isoWeekday returns the ISO 8601 day of the week, from 1 (Monday)
+// to 7 (Sunday).
+func isoWeekday(t time.Time) int64 {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+	return int64(t.Weekday())
+}
+
+// Key returns the entry Id and label of the bucket containing
+// t. Calendar buckets have an Id of 0 and labels which sort
+// chronologically; cyclic buckets are identified by their Id.
+func (b TimeBucket) Key(t time.Time) (int64, string) {
+	switch b {
+	case TimeBucketHour:
+		return 0, t.Format(HourFormat)
+	case TimeBucketDay:
+		return 0, t.Format(DayFormat)
+	case TimeBucketWeek:
+		year, week := t.ISOWeek()
+		return 0, fmt.Sprintf("%04d-W%02d", year, week)
+	case TimeBucketMonth:
+		return 0, t.Format(MonthFormat)
+	case TimeBucketYear:
+		return 0, t.Format(YearFormat)
+	case TimeBucketWeekday:
+		return isoWeekday(t), t.Weekday().String()
+	case TimeBucketHourOfDay:
+		return int64(t.Hour()), fmt.Sprintf("%02d", t.Hour())
+	case TimeBucketWeekdayHour:
+		day := isoWeekday(t)
+		return (day-1)*24 + int64(t.Hour()),
+			fmt.Sprintf("%s %02d", t.Weekday().String(), t.Hour())
+	}
+	return 0, ""
+}
+
+// start parses a calendar bucket label back to the time at which
+// the bucket begins.
+func (b TimeBucket) start(label string) (time.Time, error) {
+	switch b {
+	case TimeBucketHour:
+		return time.Parse(HourFormat, label)
+	case TimeBucketDay:
+		return time.Parse(DayFormat, label)
+	case TimeBucketWeek:
+		var year, week int
+		if _, err := fmt.Sscanf(label, "%04d-W%02d", &year, &week); err != nil {
+			return time.Time{}, err
+		}
+		// January 4th always falls in ISO week 1
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		monday := jan4.AddDate(0, 0, int(1-isoWeekday(jan4)))
+		return monday.AddDate(0, 0, (week-1)*7), nil
+	case TimeBucketMonth:
+		return time.Parse(MonthFormat, label)
+	case TimeBucketYear:
+		return time.Parse(YearFormat, label)
+	}
+	return time.Time{}, fmt.Errorf("Time bucket %s is not a calendar bucket", b)
+}
+
+// next advances t to the start of the following calendar bucket.
+func (b TimeBucket) next(t time.Time) time.Time {
+	switch b {
+	case TimeBucketHour:
+		return t.Add(time.Hour)
+	case TimeBucketDay:
+		return t.AddDate(0, 0, 1)
+	case TimeBucketWeek:
+		return t.AddDate(0, 0, 7)
+	case TimeBucketMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(1, 0, 0)
+	}
+}
+
+// cycle returns the start of every entry of a cyclic bucket, in Id
+// order.
+func (b TimeBucket) cycle() []time.Time {
+	var times []time.Time
+	switch b {
+	case TimeBucketWeekday:
+		for d := 0; d < 7; d++ {
+			times = append(times, cycleStart.AddDate(0, 0, d))
+		}
+	case TimeBucketHourOfDay:
+		for h := 0; h < 24; h++ {
+			times = append(times, cycleStart.Add(time.Duration(h)*time.Hour))
+		}
+	case TimeBucketWeekdayHour:
+		for h := 0; h < 7*24; h++ {
+			times = append(times, cycleStart.Add(time.Duration(h)*time.Hour))
+		}
+	}
+	return times
+}
+
+// Sort orders a distribution list produced for the bucket -
+// chronologically for calendar buckets, and by Id for cyclic ones.
+func (b TimeBucket) Sort(l DistributionList) {
+	if b.IsCyclic() {
+		sort.Sort(byId(l))
+	} else {
+		sort.Sort(l)
+	}
+}
+
+type byId DistributionList
+
+func (a byId) Len() int           { return len(a) }
+func (a byId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byId) Less(i, j int) bool { return a[i].Id < a[j].Id }
+
+// Fill returns a copy of a distribution list produced for the
+// bucket, with zero count entries added for every bucket between the
+// first and last entries which has no photos. Cyclic buckets are
+// filled out to their full cycle.
+func (b TimeBucket) Fill(l DistributionList) DistributionList {
+	existing := map[string]*DistributionEntry{}
+	for _, e := range l {
+		existing[e.Label] = e
+	}
+
+	var filled DistributionList
+	add := func(t time.Time) {
+		id, label := b.Key(t)
+		if e, ok := existing[label]; ok {
+			filled = append(filled, e)
+			delete(existing, label)
+		} else {
+			filled = append(filled, &DistributionEntry{Id: id, Label: label})
+		}
+	}
+
+	if b.IsCyclic() {
+		for _, t := range b.cycle() {
+			add(t)
+		}
+	} else if len(l) > 0 {
+		sorted := append(DistributionList{}, l...)
+		b.Sort(sorted)
+		first, err := b.start(sorted[0].Label)
+		if err != nil {
+			return l
+		}
+		last, err := b.start(sorted[len(sorted)-1].Label)
+		if err != nil {
+			return l
+		}
+		for t := first; !t.After(last); t = b.next(t) {
+			add(t)
+		}
+	}
+
+	// Keep anything which could not be placed in the series, such as
+	// malformed labels
+	for _, e := range existing {
+		filled = append(filled, e)
+	}
+	b.Sort(filled)
+	return filled
+}
+
+// GetTimeDistribution returns a distribution list of the number of
+// photos shot in each time bucket, with capture times interpreted
+// according to the catalog's StatsOptions time basis. Empty buckets
+// are only included if StatsOptions.FillEmptyBuckets is set.
+func (c *Catalog) GetTimeDistribution(bucket TimeBucket) (DistributionList, error) {
+	dists, err := c.GetTimeDistributions(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return dists[bucket.String()], nil
+}
+
+// GetTimeDistributions computes the distributions for several time
+// buckets in a single pass over the catalog's capture times,
+// returning them keyed by bucket name. As with GetPhotos, an
+// unrecognized capture time is an error rather than being skipped.
+func (c *Catalog) GetTimeDistributions(buckets ...TimeBucket) (map[string]DistributionList, error) {
+	const query = `
+SELECT captureTime
+FROM   Adobe_images
+WHERE  captureTime is not null
+AND    %s
+`
+	rows, err := c.db.query("get_time_distributions", fmt.Sprintf(query, c.imageCondition("id_local")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]DistributionMap, len(buckets))
+	for i := range counts {
+		counts[i] = DistributionMap{}
+	}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		if s == "" {
+			continue
+		}
+		t, _, err := parseCaptureTime(s)
+		if err != nil {
+			return nil, err
+		}
+		t = c.StatsOptions.TimeBasis.In(t)
+		for i, b := range buckets {
+			id, label := b.Key(t)
+			if e, ok := counts[i][label]; ok {
+				e.Count++
+			} else {
+				counts[i][label] = &DistributionEntry{Id: id, Label: label, Count: 1}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dists := map[string]DistributionList{}
+	for i, b := range buckets {
+		l := counts[i].ToList()
+		if c.StatsOptions.FillEmptyBuckets {
+			l = b.Fill(l)
+		} else {
+			b.Sort(l)
+		}
+		dists[b.String()] = l
+	}
+	return dists, nil
+}
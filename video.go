@@ -0,0 +1,186 @@
+package luminosity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+// VideoFileFormat is the file format Lightroom records for videos.
+const VideoFileFormat = "VIDEO"
+
+// VideoExtensions lists the upper-cased file extensions recognized as
+// videos, for catalogs which do not record the VIDEO file format.
+var VideoExtensions = []string{
+	"3GP", "AVI", "M2T", "M2TS", "M4V", "MOV", "MP4", "MPE", "MPEG", "MPG", "MTS",
+}
+
+// IsVideoFile returns true if a file with the given Lightroom file
+// format and extension is a video.
+func IsVideoFile(format, extension string) bool {
+	if format == VideoFileFormat {
+		return true
+	}
+	ext := strings.ToUpper(strings.TrimPrefix(extension, "."))
+	for _, v := range VideoExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+// videoImagesQuery selects the ids of every video in the catalog.
+var videoImagesQuery = fmt.Sprintf(`
+SELECT image.id_local
+FROM   Adobe_images  image
+JOIN   AgLibraryFile file  ON file.id_local = image.rootFile
+WHERE  image.fileFormat = '%s'
+OR     upper(file.extension) IN ('%s')`,
+	VideoFileFormat, strings.Join(VideoExtensions, "', '"))
+
+// imageCondition returns an SQL condition on a column holding image
+// ids, which excludes videos when StatsOptions.ExcludeVideos is set
+// and photos captured outside of StatsOptions.Window. It is always
+// true when neither is set.
+func (c *Catalog) imageCondition(column string) string {
+	var conditions []string
+	if c.StatsOptions.ExcludeVideos {
+		conditions = append(conditions, fmt.Sprintf("%s NOT IN (%s)", column, videoImagesQuery))
+	}
+	if w := c.StatsOptions.Window.condition(c.StatsOptions.TimeBasis, column); w != "" {
+		conditions = append(conditions, w)
+	}
+	if len(conditions) == 0 {
+		return "1 = 1"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// filtersImages returns true if the options leave some of the images
+// of a catalog out of the stats.
+func (o StatsOptions) filtersImages() bool {
+	return o.ExcludeVideos || !o.Window.IsZero()
+}
+
+// parseRational parses the rational numbers Lightroom uses for video
+// durations and frame rates, such as "30000/1001", as well as plain
+// decimal numbers.
+func parseRational(s string) (float64, bool) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(parts) == 1 {
+		return n, true
+	}
+	d, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// VideoStats summarizes the videos in a catalog. Videos are always
+// counted here, whether or not they are excluded from the other
+// distributions.
+type VideoStats struct {
+	Count int64 `json:"count"`
+
+	// TotalDuration is the sum of the durations of the videos, in
+	// seconds. Videos whose duration is not recorded in the catalog
+	// are counted in UnknownDuration.
+	TotalDuration   float64 `json:"total_duration"`
+	UnknownDuration int64   `json:"unknown_duration"`
+
+	// ByFrameSize counts videos by their displayed frame size,
+	// e.g. "1920x1080".
+	ByFrameSize DistributionList `json:"by_frame_size"`
+	// ByFrameRate counts videos by frames per second, rounded to two
+	// decimals.
+	ByFrameRate DistributionList `json:"by_frame_rate"`
+}
+
+func newVideoStats() *VideoStats {
+	return &VideoStats{
+		ByFrameSize: DistributionList{},
+		ByFrameRate: DistributionList{},
+	}
+}
+
+// Merge adds the counts and durations of other into v.
+func (v *VideoStats) Merge(other *VideoStats) {
+	if other == nil {
+		return
+	}
+	v.Count += other.Count
+	v.TotalDuration += other.TotalDuration
+	v.UnknownDuration += other.UnknownDuration
+	v.ByFrameSize = v.ByFrameSize.Merge(other.ByFrameSize)
+	v.ByFrameRate = v.ByFrameRate.Merge(other.ByFrameRate)
+	sort.Sort(byId(v.ByFrameSize))
+	sort.Sort(byId(v.ByFrameRate))
+}
+
+// GetVideoStats returns the number of videos in the catalog, their
+// total duration, and their distributions by frame size and frame
+// rate.
+func (c *Catalog) GetVideoStats() (*VideoStats, error) {
+	const query = `
+SELECT    image.fileWidth,
+          image.fileHeight,
+          image.orientation,
+          video.duration,
+          video.frame_rate
+FROM      Adobe_images  image
+LEFT JOIN AgVideoInfo   video ON video.image = image.id_local
+WHERE     image.id_local IN (%s)
+`
+	rows, err := c.db.query("get_video_stats", fmt.Sprintf(query, videoImagesQuery))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := newVideoStats()
+	sizes := DistributionMap{}
+	rates := DistributionMap{}
+	for rows.Next() {
+		var width, height null.Int
+		var orientation, duration, frameRate null.String
+		if err := rows.Scan(&width, &height, &orientation, &duration, &frameRate); err != nil {
+			return nil, err
+		}
+		stats.Count++
+		if d, ok := parseRational(duration.String); duration.Valid && ok {
+			stats.TotalDuration += d
+		} else {
+			stats.UnknownDuration++
+		}
+		if width.Int64 > 0 && height.Int64 > 0 {
+			w, h := width.Int64, height.Int64
+			if isRotated(orientation.String) {
+				w, h = h, w
+			}
+			sizes.add(w*h, fmt.Sprintf("%dx%d", w, h), 1)
+		}
+		if r, ok := parseRational(frameRate.String); frameRate.Valid && ok {
+			r = math.Round(r*100) / 100
+			rates.add(int64(r*100), strconv.FormatFloat(r, 'f', -1, 64), 1)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.ByFrameSize = sizes.ToList()
+	sort.Sort(byId(stats.ByFrameSize))
+	stats.ByFrameRate = rates.ToList()
+	sort.Sort(byId(stats.ByFrameRate))
+	return stats, nil
+}
@@ -0,0 +1,96 @@
+package luminosity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateWindow restricts stats to the photos captured on or after Since
+// and before Until. Capture dates are compared as calendar dates,
+// according to StatsOptions.TimeBasis. A zero Since or Until leaves
+// that end of the window open.
+type DateWindow struct {
+	Label string    `json:"label"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// IsZero returns true if the window is open at both ends, and so
+// selects every photo.
+func (w DateWindow) IsZero() bool {
+	return w.Since.IsZero() && w.Until.IsZero()
+}
+
+// parseWindowDate parses a year, month or day, returning its first
+// day and the first day of the following period.
+func parseWindowDate(s string) (time.Time, time.Time, error) {
+	if t, err := time.Parse(DayFormat, s); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(MonthFormat, s); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.Parse(YearFormat, s); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("Invalid date %q, expected YYYY, YYYY-MM or YYYY-MM-DD", s)
+}
+
+// ParseDateWindow parses a window given as a single year, month or
+// day (e.g. "2023", "2023-06" or "2023-06-15"), or as an inclusive
+// range of those separated by "..", such as "2023-01..2023-06". Either
+// end of a range may be omitted to leave it open.
+func ParseDateWindow(s string) (DateWindow, error) {
+	s = strings.TrimSpace(s)
+	w := DateWindow{Label: s}
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) == 1 {
+		since, until, err := parseWindowDate(s)
+		if err != nil {
+			return w, err
+		}
+		w.Since, w.Until = since, until
+		return w, nil
+	}
+	if from := strings.TrimSpace(parts[0]); from != "" {
+		since, _, err := parseWindowDate(from)
+		if err != nil {
+			return w, err
+		}
+		w.Since = since
+	}
+	if to := strings.TrimSpace(parts[1]); to != "" {
+		_, until, err := parseWindowDate(to)
+		if err != nil {
+			return w, err
+		}
+		w.Until = until
+	}
+	if w.IsZero() {
+		return w, fmt.Errorf("Invalid date window %q, at least one end is required", s)
+	}
+	if !w.Since.IsZero() && !w.Until.IsZero() && !w.Since.Before(w.Until) {
+		return w, fmt.Errorf("Invalid date window %q, start is after end", s)
+	}
+	return w, nil
+}
+
+// condition returns an SQL condition selecting the ids of the images
+// captured within the window, or an empty string if the window is
+// open at both ends.
+func (w DateWindow) condition(basis TimeBasis, column string) string {
+	if w.IsZero() {
+		return ""
+	}
+	date := basis.dateExpression("captureTime")
+	var clauses []string
+	if !w.Since.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("%s >= '%s'", date, w.Since.Format(DayFormat)))
+	}
+	if !w.Until.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("%s < '%s'", date, w.Until.Format(DayFormat)))
+	}
+	return fmt.Sprintf("%s IN (SELECT id_local FROM Adobe_images WHERE %s)",
+		column, strings.Join(clauses, " AND "))
+}